@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dc-update/internal/config"
+	"dc-update/internal/registry"
+
+	"github.com/urfave/cli/v2"
+)
+
+// settingAliases maps configuration file names to the flags they set
+var settingAliases = map[string]string{
+	"files":               "file",
+	"profiles":            "profile",
+	"warnings":            "show-warnings",
+	"maintenance-windows": "maintenance-window",
+}
+
+// configFlag returns the configuration file flag
+func configFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:        "config",
+		Usage:       "Path to the global configuration file",
+		DefaultText: config.GlobalPath(),
+		EnvVars:     []string{"DC_UPDATE_CONFIG"},
+	}
+}
+
+// loadedConfig is the result of applying the configuration files
+type loadedConfig struct {
+	Services   map[string]config.Service  // merged per-service overrides
+	Registries map[string]config.Registry // merged registry credentials by domain
+	fromFile   map[string]bool            // flags that were set from a configuration file
+}
+
+// explicit reports whether a flag was set on the command line or through its environment
+// variable rather than from a configuration file
+func (c *loadedConfig) explicit(cCtx *cli.Context, name string) bool {
+	return cCtx.IsSet(name) && !c.fromFile[name]
+}
+
+// loadConfig applies the project configuration file and then the global one to every flag
+// that wasn't set on the command line or through its environment variable, so the
+// precedence is flags > env > project > global
+func loadConfig(cCtx *cli.Context) (*loadedConfig, error) {
+	globalPath := config.GlobalPath()
+	if cCtx.IsSet("config") {
+		globalPath = cCtx.String("config")
+		if _, err := os.Stat(globalPath); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", globalPath, err)
+		}
+	}
+	global, err := config.Load(globalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The project file sits next to the compose file given on the command line, or in
+	// the current directory
+	projectDir := "."
+	if cCtx.IsSet("file") {
+		projectDir = filepath.Dir(cCtx.StringSlice("file")[0])
+	}
+	project, err := config.Load(config.ProjectPath(projectDir))
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &loadedConfig{
+		Services:   make(map[string]config.Service),
+		Registries: make(map[string]config.Registry),
+		fromFile:   make(map[string]bool),
+	}
+	for _, file := range []*config.File{project, global} {
+		if file == nil {
+			continue
+		}
+		if err := loaded.apply(cCtx, file); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range []*config.File{global, project} {
+		if file == nil {
+			continue
+		}
+		for name, service := range file.Services {
+			loaded.Services[name] = service
+		}
+		for domain, registry := range file.Registries {
+			loaded.Registries[domain] = registry
+		}
+	}
+	return loaded, nil
+}
+
+// registryAuth returns the registry credentials lookup, with the credentials of the
+// configuration files in front of those of the docker CLI configuration
+func (c *loadedConfig) registryAuth() (*registry.Auth, error) {
+	configured := make(map[string]registry.Credentials, len(c.Registries))
+	for domain, reg := range c.Registries {
+		password, err := reg.ReadPassword()
+		if err != nil {
+			return nil, fmt.Errorf("credentials of registry %s: %w", domain, err)
+		}
+		configured[domain] = registry.Credentials{Username: reg.Username, Password: password}
+	}
+	return registry.NewAuth(configured), nil
+}
+
+// apply sets the flags named in a configuration file unless they are already set
+func (c *loadedConfig) apply(cCtx *cli.Context, file *config.File) error {
+	for name, values := range file.Settings {
+		if alias, ok := settingAliases[name]; ok {
+			name = alias
+		}
+		if name == "config" || !hasFlag(cCtx, name) {
+			return fmt.Errorf("unknown setting '%s' in %s", name, file.Path)
+		}
+		if cCtx.IsSet(name) {
+			continue
+		}
+		for _, value := range values {
+			if err := cCtx.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value '%s' for %s in %s: %w", value, name, file.Path, err)
+			}
+		}
+		c.fromFile[name] = true
+	}
+	return nil
+}
+
+// hasFlag reports whether the app has a flag with the given name or alias
+func hasFlag(cCtx *cli.Context, name string) bool {
+	for _, flag := range cCtx.App.Flags {
+		for _, flagName := range flag.Names() {
+			if flagName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projectSettings are the settings a project's own configuration file may change in
+// multi-project mode
+type projectSettings struct {
+	Profiles []string
+	Services map[string]config.Service // overrides from the project's own file
+	Windows  []string
+}
+
+// forProject returns the settings for a project in multi-project mode, where the
+// project's own configuration file takes precedence over the global one. Only profiles,
+// maintenance windows and services are read from project files in this mode.
+func (c *loadedConfig) forProject(cCtx *cli.Context, projectDir string, profiles []string) (*projectSettings, error) {
+	settings := &projectSettings{
+		Profiles: profiles,
+		Windows:  cCtx.StringSlice("maintenance-window"),
+	}
+	file, err := config.Load(config.ProjectPath(projectDir))
+	if err != nil || file == nil {
+		return settings, err
+	}
+
+	if !c.explicit(cCtx, "profile") {
+		if values, ok := file.Settings["profiles"]; ok {
+			settings.Profiles = values
+		} else if values, ok := file.Settings["profile"]; ok {
+			settings.Profiles = values
+		}
+	}
+	if !c.explicit(cCtx, "maintenance-window") {
+		if values, ok := file.Settings["maintenance-windows"]; ok {
+			settings.Windows = values
+		} else if values, ok := file.Settings["maintenance-window"]; ok {
+			settings.Windows = values
+		}
+	}
+
+	settings.Services = file.Services
+	return settings, nil
+}
+
+// servicesFor returns the per-service overrides of a project in multi-project mode: the
+// global ones that apply to it, each replaced by the project file's override of the same
+// service
+func (c *loadedConfig) servicesFor(project string, settings *projectSettings) map[string]config.Service {
+	services := config.ProjectServices(c.Services, project)
+	for name, service := range config.ProjectServices(settings.Services, project) {
+		services[name] = service
+	}
+	return services
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"dc-update/internal/config"
+
+	"github.com/urfave/cli/v2"
+)
+
+// runWithConfig runs an app with a few of dc-update's flags and the given arguments,
+// loading the global configuration file at globalPath and the project file next to the
+// compose file, and passes the result to check
+func runWithConfig(t *testing.T, args []string, check func(*cli.Context, *loadedConfig)) {
+	t.Helper()
+	app := &cli.App{
+		Name: "dc-update",
+		Flags: []cli.Flag{
+			configFlag(),
+			&cli.StringSliceFlag{Name: "file", Aliases: []string{"f"}},
+			&cli.StringSliceFlag{Name: "profile"},
+			&cli.StringFlag{Name: "backend", EnvVars: []string{"DC_UPDATE_BACKEND"}},
+			&cli.StringFlag{Name: "strategy", Value: "recreate"},
+			&cli.IntFlag{Name: "prune-keep"},
+		},
+		Action: func(cCtx *cli.Context) error {
+			loaded, err := loadConfig(cCtx)
+			if err != nil {
+				return err
+			}
+			check(cCtx, loaded)
+			return nil
+		},
+	}
+	if err := app.Run(append([]string{"dc-update"}, args...)); err != nil {
+		t.Fatalf("run %v: %v", args, err)
+	}
+}
+
+// writeFile writes a file in a test's temporary directory
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name         string
+		global       string
+		project      string
+		env          string // DC_UPDATE_BACKEND
+		args         []string
+		want         string
+		wantExplicit bool
+	}{
+		{"default", "", "", "", nil, "", false},
+		{"global", "backend: native\n", "", "", nil, "native", false},
+		{"project over global", "backend: native\n", "backend: cli\n", "", nil, "cli", false},
+		{"env over project", "backend: native\n", "backend: cli\n", "native", nil, "native", true},
+		{"flag over env", "backend: native\n", "backend: native\n", "native", []string{"--backend", "cli"}, "cli", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			globalPath := filepath.Join(dir, "config.yaml")
+			writeFile(t, globalPath, tt.global)
+			if tt.project != "" {
+				writeFile(t, config.ProjectPath(dir), tt.project)
+			}
+			t.Setenv("DC_UPDATE_BACKEND", tt.env)
+			if tt.env == "" {
+				os.Unsetenv("DC_UPDATE_BACKEND")
+			}
+
+			args := append([]string{"--config", globalPath, "--file", filepath.Join(dir, "compose.yaml")}, tt.args...)
+			runWithConfig(t, args, func(cCtx *cli.Context, loaded *loadedConfig) {
+				if got := cCtx.String("backend"); got != tt.want {
+					t.Errorf("backend = %q, want %q", got, tt.want)
+				}
+				if got := loaded.explicit(cCtx, "backend"); got != tt.wantExplicit {
+					t.Errorf("explicit(backend) = %v, want %v", got, tt.wantExplicit)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadConfigMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	globalPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, globalPath, "strategy: rolling\nprune_keep: 2\nservices:\n  web:\n    skip: true\n  db:\n    skip: true\n")
+	writeFile(t, config.ProjectPath(dir), "prune-keep: 4\nprofiles: [web, worker]\nservices:\n  web:\n    health_timeout: 1m\n")
+
+	runWithConfig(t, []string{"--config", globalPath, "-f", filepath.Join(dir, "compose.yaml")}, func(cCtx *cli.Context, loaded *loadedConfig) {
+		if got := cCtx.String("strategy"); got != "rolling" {
+			t.Errorf("strategy = %q, want the global file's rolling", got)
+		}
+		if got := cCtx.Int("prune-keep"); got != 4 {
+			t.Errorf("prune-keep = %d, want the project file's 4", got)
+		}
+		if got := cCtx.StringSlice("profile"); !reflect.DeepEqual(got, []string{"web", "worker"}) {
+			t.Errorf("profile = %v, want [web worker]", got)
+		}
+		// The project file's override replaces the global one of the same service
+		want := map[string]config.Service{
+			"web": {HealthTimeout: time.Minute},
+			"db":  {Skip: true},
+		}
+		if !reflect.DeepEqual(loaded.Services, want) {
+			t.Errorf("Services = %+v, want %+v", loaded.Services, want)
+		}
+	})
+}
+
+func TestLoadConfigUnknownSetting(t *testing.T) {
+	dir := t.TempDir()
+	globalPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, globalPath, "stratgy: rolling\n")
+
+	app := &cli.App{
+		Name:  "dc-update",
+		Flags: []cli.Flag{configFlag(), &cli.StringFlag{Name: "strategy"}},
+		Action: func(cCtx *cli.Context) error {
+			_, err := loadConfig(cCtx)
+			return err
+		},
+	}
+	if err := app.Run([]string{"dc-update", "--config", globalPath}); err == nil {
+		t.Error("loadConfig with an unknown setting: error = nil")
+	}
+}
+
+func TestServicesFor(t *testing.T) {
+	loaded := &loadedConfig{Services: map[string]config.Service{
+		"web":      {Strategy: "rolling", HealthTimeout: time.Minute},
+		"db":       {Skip: true},
+		"blog/web": {HealthTimeout: 5 * time.Minute},
+		"shop/db":  {Strategy: "recreate"},
+	}}
+	tests := []struct {
+		name    string
+		project string
+		own     map[string]config.Service // from the project's own file
+		want    map[string]config.Service
+	}{
+		{
+			name:    "global overrides",
+			project: "wiki",
+			want: map[string]config.Service{
+				"web": {Strategy: "rolling", HealthTimeout: time.Minute},
+				"db":  {Skip: true},
+			},
+		},
+		{
+			name:    "scoped global override",
+			project: "blog",
+			want: map[string]config.Service{
+				"web": {Strategy: "rolling", HealthTimeout: 5 * time.Minute},
+				"db":  {Skip: true},
+			},
+		},
+		{
+			name:    "project file replaces the global override",
+			project: "blog",
+			own: map[string]config.Service{
+				"web":      {Strategy: "recreate"},
+				"shop/api": {Skip: true},
+			},
+			want: map[string]config.Service{
+				"web": {Strategy: "recreate"},
+				"db":  {Skip: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loaded.servicesFor(tt.project, &projectSettings{Services: tt.own})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("servicesFor(%s) = %+v, want %+v", tt.project, got, tt.want)
+			}
+		})
+	}
+}
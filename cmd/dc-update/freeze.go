@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc-update/internal/state"
+
+	"github.com/urfave/cli/v2"
+)
+
+// digestPattern matches an image ID or registry digest, possibly shortened
+var digestPattern = regexp.MustCompile(`^(sha256:)?[0-9a-f]{12,64}$`)
+
+// freezeCommand returns the command holding back updates of a service
+func freezeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "freeze",
+		Usage:     "Hold back updates of a service, or only one bad release of it with --digest. Lists the freezes without a service",
+		ArgsUsage: "[SERVICE]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "End of the freeze: a date (2026-11-01), a date and time (\"2026-11-01 18:00\") or a duration (72h, 7d). Without it the freeze lasts until `dc-update unfreeze`",
+			},
+			&cli.StringFlag{
+				Name:  "digest",
+				Usage: "Only skip the release with this image ID or registry digest, e.g. sha256:...",
+			},
+			&cli.StringFlag{
+				Name:  "reason",
+				Usage: "Why the service is frozen, shown in the summary",
+			},
+		},
+		Action: freeze,
+	}
+}
+
+// unfreezeCommand returns the command lifting the freezes of a service
+func unfreezeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "unfreeze",
+		Usage:     "Remove all freezes of a service",
+		ArgsUsage: "SERVICE",
+		Action:    unfreeze,
+	}
+}
+
+// freeze adds a freeze for a service, or lists the current freezes
+func freeze(cCtx *cli.Context) error {
+	args, err := argsWithFlags(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: dc-update [options] freeze [SERVICE] [--until DATE] [--digest sha256:...] [--reason TEXT]")
+	}
+	if len(args) == 0 {
+		return listFreezes(cCtx)
+	}
+	serviceName := args[0]
+
+	now := time.Now()
+	entry := state.Freeze{
+		Service:   serviceName,
+		Reason:    cCtx.String("reason"),
+		CreatedAt: now,
+	}
+	if value := cCtx.String("until"); value != "" {
+		until, err := parseUntil(value, now)
+		if err != nil {
+			return err
+		}
+		if !until.After(now) {
+			return fmt.Errorf("--until %s is in the past", value)
+		}
+		entry.Until = &until
+	}
+	if digest := strings.ToLower(cCtx.String("digest")); digest != "" {
+		if !digestPattern.MatchString(digest) {
+			return fmt.Errorf("invalid --digest '%s' (expected an image ID or sha256: digest)", digest)
+		}
+		entry.Digest = digest
+	}
+
+	updater, err := openSingleProject(cCtx)
+	if err != nil {
+		return err
+	}
+	defer updater.Close()
+	if err := updater.Compose.ValidateServiceExists(serviceName); err != nil {
+		return err
+	}
+	entry.Project = updater.Compose.ProjectName()
+
+	path := cCtx.String("state-file")
+	st, err := state.Load(path)
+	if err != nil {
+		return err
+	}
+	st.PruneFreezes(now)
+	st.AddFreeze(entry)
+	if err := st.Save(path); err != nil {
+		return err
+	}
+
+	fmt.Printf("🧊 %s/%s: %s\n", entry.Project, serviceName, entry)
+	return nil
+}
+
+// unfreeze removes the freezes of a service
+func unfreeze(cCtx *cli.Context) error {
+	if cCtx.NArg() != 1 {
+		return fmt.Errorf("usage: dc-update [options] unfreeze SERVICE")
+	}
+	serviceName := cCtx.Args().First()
+
+	updater, err := openSingleProject(cCtx)
+	if err != nil {
+		return err
+	}
+	defer updater.Close()
+	project := updater.Compose.ProjectName()
+
+	path := cCtx.String("state-file")
+	st, err := state.Load(path)
+	if err != nil {
+		return err
+	}
+	removed := st.RemoveFreezes(project, serviceName)
+	if removed == 0 {
+		fmt.Printf("%s/%s is not frozen\n", project, serviceName)
+		return nil
+	}
+	if err := st.Save(path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d freeze(s) of %s/%s\n", removed, project, serviceName)
+	return nil
+}
+
+// listFreezes prints the freezes in effect in every project
+func listFreezes(cCtx *cli.Context) error {
+	if _, err := loadConfig(cCtx); err != nil {
+		return err
+	}
+	st, err := state.Load(cCtx.String("state-file"))
+	if err != nil {
+		return err
+	}
+
+	st.PruneFreezes(time.Now())
+	if len(st.Freezes) == 0 {
+		fmt.Println("No services are frozen")
+		return nil
+	}
+	for _, entry := range st.Freezes {
+		fmt.Printf("🧊 %s/%s: %s\n", entry.Project, entry.Service, entry)
+	}
+	return nil
+}
+
+// parseUntil parses the end of a freeze: a date, which means the start of that day, a
+// date and time, or a duration from now
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, n), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --until '%s' (expected e.g. 2026-11-01, \"2026-11-01 18:00\" or 72h)", value)
+}
+
+// argsWithFlags returns the arguments of a command, applying the command's flags given
+// after them, e.g. `freeze db --until 2026-11-01`. urfave/cli stops parsing flags at the
+// first argument.
+func argsWithFlags(cCtx *cli.Context) ([]string, error) {
+	var args []string
+	rest := cCtx.Args().Slice()
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		if arg == "--" {
+			args = append(args, rest[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			args = append(args, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		flag := commandFlag(cCtx.Command, name)
+		if flag == nil {
+			return nil, fmt.Errorf("unknown flag %s for %s", arg, cCtx.Command.Name)
+		}
+		if _, isBool := flag.(*cli.BoolFlag); isBool && !hasValue {
+			value, hasValue = "true", true
+		}
+		if !hasValue {
+			if i+1 >= len(rest) {
+				return nil, fmt.Errorf("flag %s needs a value", arg)
+			}
+			i++
+			value = rest[i]
+		}
+		if err := cCtx.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value '%s' for %s: %w", value, arg, err)
+		}
+	}
+	return args, nil
+}
+
+// commandFlag returns the flag of a command with the given name or alias, nil if it has none
+func commandFlag(command *cli.Command, name string) cli.Flag {
+	for _, flag := range command.Flags {
+		for _, flagName := range flag.Names() {
+			if flagName == name {
+				return flag
+			}
+		}
+	}
+	return nil
+}
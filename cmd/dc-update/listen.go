@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"dc-update/internal/core"
+	"dc-update/internal/notify"
+	"dc-update/internal/report"
+)
+
+// runMQTTListener keeps dc-update running, periodically publishing the update state
+// of every service and installing updates when Home Assistant requests them
+func runMQTTListener(updater *core.UpdaterOptions, serviceNames []string, notifiers []notify.Notifier, interval time.Duration) error {
+	var publisher *notify.MQTTNotifier
+	for _, n := range notifiers {
+		if mqttNotifier, ok := n.(*notify.MQTTNotifier); ok {
+			publisher = mqttNotifier
+		}
+	}
+
+	// The listener stops at the first interrupt, once a running check or install is done
+	ctx := updater.Stop
+
+	// Requests arriving while the queue is full are dropped rather than blocking the MQTT
+	// client, which would stall its other messages and keepalives
+	installs := make(chan string, len(serviceNames))
+	if err := publisher.SubscribeInstall(func(service string) {
+		select {
+		case installs <- service:
+		default:
+			fmt.Fprintf(os.Stderr, "Ignoring install request for %s, too many requests are queued\n", service)
+		}
+	}); err != nil {
+		return err
+	}
+
+	// Latest check result per service, used to report install progress
+	lastResults := make(map[string]report.ServiceResult)
+
+	check := func() {
+		updater.ResetReport()
+		// Failures are logged by the updater and published with the report
+		updater.CheckContainers(serviceNames)
+		updater.Report.Finish()
+		for _, result := range updater.Report.All() {
+			lastResults[result.Service] = result
+		}
+		if err := publisher.Notify(updater.Report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	install := func(serviceName string) {
+		if !slices.Contains(serviceNames, serviceName) {
+			fmt.Fprintf(os.Stderr, "Ignoring install request for unknown service %s\n", serviceName)
+			return
+		}
+
+		if last, ok := lastResults[serviceName]; ok {
+			if err := publisher.PublishInProgress(last); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+		}
+
+		updater.ResetReport()
+		// Failures are logged by the updater and published with the report
+		updater.UpdateContainer(serviceName)
+		updater.Report.Finish()
+		for _, result := range updater.Report.All() {
+			lastResults[result.Service] = result
+		}
+
+		if err := notify.Dispatch(notifiers, updater.Report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	fmt.Printf("Listening for Home Assistant install requests, checking every %s\n", interval)
+	check()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			check()
+		case serviceName := <-installs:
+			install(serviceName)
+		}
+	}
+}
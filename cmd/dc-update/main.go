@@ -1,12 +1,21 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
+	"dc-update/internal/compose"
+	"dc-update/internal/config"
 	"dc-update/internal/core"
+	"dc-update/internal/docker"
+	"dc-update/internal/interrupt"
+	"dc-update/internal/notify"
+	"dc-update/internal/retry"
+	"dc-update/internal/schedule"
+	"dc-update/internal/state"
 
 	"github.com/urfave/cli/v2"
 )
@@ -23,14 +32,19 @@ func main() {
 		Name:  "dc-update",
 		Version: version,
 		Usage: "An opinionated script for updating large docker-compose based systems",
-		UsageText: "dc-update [CONTAINER_NAME]...",
+		UsageText: "dc-update [options] [CONTAINER_NAME]...\ndc-update [options] restore SERVICE [BACKUP]\ndc-update [options] freeze|unfreeze SERVICE",
 		Description: `dc-update intelligently updates only containers that have newer images available, avoiding unnecessary restarts.`,
-		Flags: []cli.Flag{
-			&cli.StringFlag{
+		Flags: append([]cli.Flag{
+			configFlag(),
+			&cli.StringSliceFlag{
 				Name:    "file",
 				Aliases: []string{"f"},
-				Usage:   "Path to docker-compose.yml file",
-				Value:   "docker-compose.yml",
+				Usage:   "Path to docker-compose.yml file. Can be called multiple times to merge files",
+				Value:   cli.NewStringSlice("docker-compose.yml"),
+			},
+			&cli.StringSliceFlag{
+				Name:  "profile",
+				Usage: "Compose profile to enable. Can be called multiple times",
 			},
 			&cli.StringSliceFlag{
 				Name:    "build",
@@ -41,21 +55,163 @@ func main() {
 				Name:  "show-warnings",
 				Usage: "Show warnings for containers that aren't running (default: false)",
 			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "Number of services updated at once",
+				Value: core.DefaultConcurrency,
+			},
 			&cli.BoolFlag{
 				Name:    "non-interactive",
 				Aliases: []string{"n"},
 				Usage:   "Disable spinners and use plain text output",
 			},
-		},
+			&cli.StringFlag{
+				Name:    "engine",
+				Usage:   "Container engine to manage: auto, docker or podman",
+				Value:   docker.EngineAuto,
+				EnvVars: []string{"DC_UPDATE_ENGINE"},
+			},
+			&cli.StringFlag{
+				Name:    "host",
+				Aliases: []string{"H"},
+				Usage:   "Daemon to connect to, e.g. ssh://user@host, tcp://host:2376 or unix:///path/to/socket",
+				EnvVars: []string{"DC_UPDATE_HOST"},
+			},
+			&cli.StringFlag{
+				Name:    "context",
+				Usage:   "Docker context to connect to (see `docker context ls`)",
+				EnvVars: []string{"DC_UPDATE_CONTEXT"},
+			},
+			&cli.StringFlag{
+				Name:    "backend",
+				Usage:   "How to run compose operations: cli (docker compose) or native (Docker Engine API, no compose CLI needed)",
+				Value:   compose.BackendCLI,
+				EnvVars: []string{"DC_UPDATE_BACKEND"},
+			},
+			&cli.StringFlag{
+				Name:        "compose-command",
+				Usage:       "Compose CLI to use, e.g. \"docker-compose\" or \"docker compose\"",
+				DefaultText: "auto-detected",
+				EnvVars:     []string{"DC_UPDATE_COMPOSE_COMMAND"},
+			},
+			&cli.StringFlag{
+				Name:  "scan",
+				Usage: "Update every compose project found in the directory tree below this path instead of a single compose file",
+			},
+			&cli.StringSliceFlag{
+				Name:  "scan-ignore",
+				Usage: "Directory name or path pattern to skip when scanning, e.g. \"old-*\". Can be called multiple times",
+			},
+			&cli.BoolFlag{
+				Name:  "all-projects",
+				Usage: "Update every compose project with running containers, found through their compose labels",
+			},
+			&cli.IntFlag{
+				Name:  "project-concurrency",
+				Usage: "Number of projects updated at once with --scan or --all-projects",
+				Value: core.DefaultProjectConcurrency,
+			},
+			&cli.BoolFlag{
+				Name:  "prune",
+				Usage: "Remove the previous image of each updated service once its replacement is running",
+			},
+			&cli.IntFlag{
+				Name:  "prune-keep",
+				Usage: "Number of superseded images to keep per repository for rollback when pruning",
+			},
+			&cli.StringFlag{
+				Name:  "strategy",
+				Usage: "How to update services with several replicas: recreate (stop all, then start) or rolling",
+				Value: core.StrategyRecreate,
+			},
+			&cli.IntFlag{
+				Name:  "rolling-batch-size",
+				Usage: "Number of replicas replaced at once during a rolling update",
+				Value: core.DefaultRollingBatchSize,
+			},
+			&cli.DurationFlag{
+				Name:  "rolling-pause",
+				Usage: "Pause between batches of a rolling update",
+			},
+			&cli.DurationFlag{
+				Name:  "health-timeout",
+				Usage: "How long to wait for a new replica to become healthy during a rolling update",
+				Value: core.DefaultHealthTimeout,
+			},
+			&cli.DurationFlag{
+				Name:  "pull-timeout",
+				Usage: "How long pulling an image may take before the service fails, 0 for no limit",
+				Value: core.DefaultPullTimeout,
+			},
+			&cli.IntFlag{
+				Name:  "pull-retries",
+				Usage: "How often a pull failing with a timeout, network or server error or a rate limit is retried, with exponential backoff",
+				Value: retry.DefaultAttempts - 1,
+			},
+			&cli.StringFlag{
+				Name:  "hub-rate-limit",
+				Usage: "What to do when fewer Docker Hub pulls are left than images to pull: warn, defer (pull what the budget allows, defer the other services) or off (don't check)",
+				Value: core.HubRateLimitWarn,
+			},
+			&cli.DurationFlag{
+				Name:  "stop-timeout",
+				Usage: "How long stopping a service may take before it fails, 0 for no limit",
+				Value: core.DefaultStopTimeout,
+			},
+			&cli.DurationFlag{
+				Name:  "start-timeout",
+				Usage: "How long starting a service may take before it fails, 0 for no limit",
+				Value: core.DefaultStartTimeout,
+			},
+			&cli.BoolFlag{
+				Name:  "backup",
+				Usage: "Archive the named volumes of each service before it is recreated",
+			},
+			&cli.StringFlag{
+				Name:    "backup-dir",
+				Usage:   "Directory to store volume backups in, one subdirectory per project and service",
+				Value:   config.BackupDir(),
+				EnvVars: []string{"DC_UPDATE_BACKUP_DIR"},
+			},
+			&cli.IntFlag{
+				Name:  "backup-keep",
+				Usage: "Number of backups to keep per service",
+				Value: core.DefaultBackupKeep,
+			},
+			&cli.DurationFlag{
+				Name:  "backup-max-age",
+				Usage: "Remove backups older than this, e.g. 720h. The newest backup of a service is always kept",
+			},
+			&cli.StringFlag{
+				Name:  "backup-image",
+				Usage: "Image of the helper container that archives volumes, it needs sh, find and tar",
+				Value: core.DefaultBackupImage,
+			},
+			&cli.StringSliceFlag{
+				Name:  "maintenance-window",
+				Usage: "Only install updates during this window, e.g. \"Sun 02:00-05:00 Europe/Berlin\". Updates outside it are deferred and reported. Can be called multiple times",
+			},
+			&cli.BoolFlag{
+				Name:  "pull-only",
+				Usage: "Pull new images without restarting anything, e.g. to pre-stage them ahead of a maintenance window",
+			},
+			&cli.DurationFlag{
+				Name:  "min-age",
+				Usage: "Only install images that were built at least this long ago, e.g. 48h. Younger images are reported as pending",
+			},
+			&cli.StringFlag{
+				Name:    "state-file",
+				Usage:   "File dc-update keeps freezes in",
+				Value:   config.StatePath(),
+				EnvVars: []string{"DC_UPDATE_STATE_FILE"},
+			},
+		}, notifierFlags()...),
+		Commands: []*cli.Command{restoreCommand(), freezeCommand(), unfreezeCommand()},
 		Action: func(cCtx *cli.Context) error {
-			// Validate docker-compose file existence
-			dockerComposeFile := cCtx.String("file")
-			if !filepath.IsAbs(dockerComposeFile) {
-				dockerComposeFile = filepath.Join(".", dockerComposeFile)
-			}
-			
-			if _, err := os.Stat(dockerComposeFile); os.IsNotExist(err) {
-				return fmt.Errorf("docker-compose file does not exist: %s", dockerComposeFile)
+			// Fill in settings not given as flags from the configuration files
+			cfg, err := loadConfig(cCtx)
+			if err != nil {
+				return err
 			}
 
 			// Get CLI arguments
@@ -63,37 +219,137 @@ func main() {
 			buildContainers := cCtx.StringSlice("build")
 			showWarnings := cCtx.Bool("show-warnings")
 			nonInteractive := cCtx.Bool("non-interactive")
+			scanRoot := cCtx.String("scan")
+			allProjects := cCtx.Bool("all-projects")
+
+			composeConfig := compose.Config{
+				Backend:        cCtx.String("backend"),
+				Profiles:       cCtx.StringSlice("profile"),
+				ComposeCommand: cCtx.String("compose-command"),
+			}
+
+			if keep := cCtx.Int("prune-keep"); keep < 0 {
+				return fmt.Errorf("invalid --prune-keep %d: must not be negative", keep)
+			}
+			if scanRoot != "" && allProjects {
+				return fmt.Errorf("--scan and --all-projects cannot be used together")
+			}
+			if scanRoot != "" || allProjects {
+				if len(containerNames) > 0 || cfg.explicit(cCtx, "build") || cCtx.Bool("mqtt-listen") {
+					return fmt.Errorf("--scan and --all-projects cannot be combined with service names, --build or --mqtt-listen")
+				}
+			} else {
+				if err := setComposeFiles(cCtx, &composeConfig); err != nil {
+					return err
+				}
+			}
+
+			registryAuth, err := cfg.registryAuth()
+			if err != nil {
+				return err
+			}
+
+			// The first Ctrl-C lets the running updates finish, the second one aborts them
+			stop, abort, release := interrupt.Contexts(cCtx.Context)
+			defer release()
 
 			// Initialize updater
-			updater, err := core.NewUpdaterOptions(dockerComposeFile, showWarnings, nonInteractive)
+			updater, err := core.NewUpdaterOptions(abort, docker.Config{
+				Engine:  cCtx.String("engine"),
+				Host:    cCtx.String("host"),
+				Context: cCtx.String("context"),
+				Auth:    registryAuth,
+			}, composeConfig, showWarnings, nonInteractive)
 			if err != nil {
 				return fmt.Errorf("failed to initialize updater: %w", err)
 			}
 			defer updater.Close()
+			updater.Stop = stop
+			updater.Prune = cCtx.Bool("prune")
+			updater.PruneKeep = cCtx.Int("prune-keep")
+			updater.RollingBatchSize = cCtx.Int("rolling-batch-size")
+			updater.RollingPause = cCtx.Duration("rolling-pause")
+			updater.HealthTimeout = cCtx.Duration("health-timeout")
+			updater.Concurrency = cCtx.Int("concurrency")
+			applyTimeoutSettings(cCtx, updater)
+			applyBackupSettings(cCtx, updater)
+			updater.MinAge = cCtx.Duration("min-age")
+			updater.PullOnly = cCtx.Bool("pull-only")
+			if err := applyHoldSettings(cCtx, updater); err != nil {
+				return err
+			}
+			updater.Services = cfg.Services
+			if updater.Compose != nil {
+				updater.Services = config.ProjectServices(cfg.Services, updater.Compose.ProjectName())
+				if err := updater.LoadServiceExtensions(); err != nil {
+					return err
+				}
+			}
+			if err := updater.ValidateServices(); err != nil {
+				return fmt.Errorf("invalid service settings: %w", err)
+			}
+			switch strategy := cCtx.String("strategy"); strategy {
+			case core.StrategyRecreate, core.StrategyRolling:
+				updater.Strategy = strategy
+			default:
+				return fmt.Errorf("unknown update strategy '%s' (expected recreate or rolling)", strategy)
+			}
+			switch mode := cCtx.String("hub-rate-limit"); mode {
+			case core.HubRateLimitWarn, core.HubRateLimitDefer, core.HubRateLimitOff:
+				updater.HubRateLimit = mode
+			default:
+				return fmt.Errorf("unknown Docker Hub rate limit handling '%s' (expected warn, defer or off)", mode)
+			}
+
+			if scanRoot != "" {
+				return runScan(cCtx, updater, cfg, composeConfig, scanRoot)
+			}
+			if allProjects {
+				return runAllProjects(cCtx, updater, cfg, composeConfig)
+			}
+
+			notifiers, err := buildNotifiers(cCtx, updater.Report)
+			if err != nil {
+				return fmt.Errorf("invalid notification settings: %w", err)
+			}
+			defer notify.Close(notifiers)
 
 			// Determine service names - use args if provided, otherwise get all services
 			var serviceNames []string
 			if len(containerNames) > 0 {
 				serviceNames = containerNames
 			} else {
-				allServices, err := updater.ComposeOpts.GetServiceNames()
+				allServices, err := updater.Compose.GetServiceNames()
 				if err != nil {
 					return fmt.Errorf("failed to get service names: %w", err)
 				}
-				serviceNames = allServices
+				serviceNames = updater.SelectServices(allServices)
 			}
 
-			// Handle build containers if specified
-			if len(buildContainers) > 0 {
-				fmt.Printf("Building containers: %v\n", buildContainers)
-				if err := updater.ComposeOpts.BuildContainers(buildContainers); err != nil {
-					return fmt.Errorf("failed to build containers: %w", err)
-				}
+			// Handle build containers if specified, on the command line or in the configuration
+			if err := updater.Build(updater.BuildList(buildContainers)); err != nil {
+				return err
+			}
+
+			if cCtx.Bool("mqtt-listen") {
+				return runMQTTListener(updater, serviceNames, notifiers, cCtx.Duration("mqtt-check-interval"))
 			}
 
 			// Process containers with controlled concurrency
-			if err := updater.UpdateContainersConcurrently(serviceNames); err != nil {
-				return fmt.Errorf("failed to update containers: %w", err)
+			updateErr := updater.UpdateContainersConcurrently(serviceNames)
+			updater.Report.Finish()
+			fmt.Printf("\n%s", updater.Report.Summary())
+
+			// Notify even if some containers failed, failures are part of the digest
+			if err := notify.Dispatch(notifiers, updater.Report); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+
+			if updateErr != nil {
+				return fmt.Errorf("failed to update containers: %w", updateErr)
+			}
+			if stop.Err() != nil {
+				return errInterrupted
 			}
 
 			return nil
@@ -103,4 +359,88 @@ func main() {
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
+}
+
+// errInterrupted is returned by runs that were interrupted with Ctrl-C or SIGTERM
+var errInterrupted = errors.New("interrupted")
+
+// setComposeFiles checks that the compose files given with --file exist and sets them in
+// composeConfig
+func setComposeFiles(cCtx *cli.Context, composeConfig *compose.Config) error {
+	var composeFiles []string
+	for _, dockerComposeFile := range cCtx.StringSlice("file") {
+		if !filepath.IsAbs(dockerComposeFile) {
+			dockerComposeFile = filepath.Join(".", dockerComposeFile)
+		}
+
+		if _, err := os.Stat(dockerComposeFile); os.IsNotExist(err) {
+			return fmt.Errorf("docker-compose file does not exist: %s", dockerComposeFile)
+		}
+		composeFiles = append(composeFiles, dockerComposeFile)
+	}
+	composeConfig.ComposeFile = composeFiles[0]
+	composeConfig.ExtraFiles = composeFiles[1:]
+	return nil
+}
+
+// openSingleProject opens the project of the compose files given with --file, for
+// commands that work on a single project's services
+func openSingleProject(cCtx *cli.Context) (*core.UpdaterOptions, error) {
+	cfg, err := loadConfig(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	registryAuth, err := cfg.registryAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	composeConfig := compose.Config{
+		Backend:        cCtx.String("backend"),
+		Profiles:       cCtx.StringSlice("profile"),
+		ComposeCommand: cCtx.String("compose-command"),
+	}
+	if err := setComposeFiles(cCtx, &composeConfig); err != nil {
+		return nil, err
+	}
+
+	updater, err := core.NewUpdaterOptions(cCtx.Context, docker.Config{
+		Engine:  cCtx.String("engine"),
+		Host:    cCtx.String("host"),
+		Context: cCtx.String("context"),
+		Auth:    registryAuth,
+	}, composeConfig, false, cCtx.Bool("non-interactive"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize updater: %w", err)
+	}
+	return updater, nil
+}
+
+// applyHoldSettings sets the maintenance windows and loads the freezes
+func applyHoldSettings(cCtx *cli.Context, updater *core.UpdaterOptions) error {
+	windows, err := schedule.ParseWindows(cCtx.StringSlice("maintenance-window"))
+	if err != nil {
+		return err
+	}
+	updater.Windows = windows
+
+	updater.State, err = state.Load(cCtx.String("state-file"))
+	return err
+}
+
+// applyTimeoutSettings copies the time limits of pulls, stops and starts to the updater
+func applyTimeoutSettings(cCtx *cli.Context, updater *core.UpdaterOptions) {
+	updater.PullTimeout = cCtx.Duration("pull-timeout")
+	updater.StopTimeout = cCtx.Duration("stop-timeout")
+	updater.StartTimeout = cCtx.Duration("start-timeout")
+	updater.PullRetries = cCtx.Int("pull-retries")
+}
+
+// applyBackupSettings copies the volume backup flags to the updater
+func applyBackupSettings(cCtx *cli.Context, updater *core.UpdaterOptions) {
+	updater.Backup = cCtx.Bool("backup")
+	updater.BackupDir = cCtx.String("backup-dir")
+	updater.BackupKeep = cCtx.Int("backup-keep")
+	updater.BackupMaxAge = cCtx.Duration("backup-max-age")
+	updater.BackupImage = cCtx.String("backup-image")
 }
\ No newline at end of file
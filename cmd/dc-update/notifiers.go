@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"dc-update/internal/notify"
+	"dc-update/internal/report"
+
+	"github.com/urfave/cli/v2"
+)
+
+const notificationsCategory = "Notifications"
+
+// notifierFlags returns the CLI flags used to configure notifications
+func notifierFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "smtp-host",
+			Usage:    "SMTP server to send a digest of changes and failures to",
+			EnvVars:  []string{"DC_UPDATE_SMTP_HOST"},
+			Category: notificationsCategory,
+		},
+		&cli.IntFlag{
+			Name:        "smtp-port",
+			Usage:       "SMTP server port",
+			DefaultText: "587, or 465 with --smtp-security tls",
+			EnvVars:     []string{"DC_UPDATE_SMTP_PORT"},
+			Category:    notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "smtp-security",
+			Usage:    "SMTP transport security: none, starttls or tls",
+			Value:    notify.SMTPSecurityStartTLS,
+			EnvVars:  []string{"DC_UPDATE_SMTP_SECURITY"},
+			Category: notificationsCategory,
+		},
+		&cli.BoolFlag{
+			Name:     "smtp-skip-verify",
+			Usage:    "Skip TLS certificate verification for the SMTP server",
+			EnvVars:  []string{"DC_UPDATE_SMTP_SKIP_VERIFY"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "smtp-username",
+			Usage:    "SMTP username",
+			EnvVars:  []string{"DC_UPDATE_SMTP_USERNAME"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "smtp-password",
+			Usage:    "SMTP password",
+			EnvVars:  []string{"DC_UPDATE_SMTP_PASSWORD"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "smtp-from",
+			Usage:    "Sender address for email notifications",
+			EnvVars:  []string{"DC_UPDATE_SMTP_FROM"},
+			Category: notificationsCategory,
+		},
+		&cli.StringSliceFlag{
+			Name:     "smtp-to",
+			Usage:    "Recipient address for email notifications. Can be called multiple times",
+			EnvVars:  []string{"DC_UPDATE_SMTP_TO"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "mqtt-broker",
+			Usage:    "MQTT broker to publish per-service update state to, e.g. tcp://localhost:1883",
+			EnvVars:  []string{"DC_UPDATE_MQTT_BROKER"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "mqtt-username",
+			Usage:    "MQTT username",
+			EnvVars:  []string{"DC_UPDATE_MQTT_USERNAME"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "mqtt-password",
+			Usage:    "MQTT password",
+			EnvVars:  []string{"DC_UPDATE_MQTT_PASSWORD"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-client-id",
+			Usage:       "MQTT client ID",
+			DefaultText: "derived from host and project name",
+			EnvVars:     []string{"DC_UPDATE_MQTT_CLIENT_ID"},
+			Category:    notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "mqtt-discovery-prefix",
+			Usage:    "Home Assistant MQTT discovery prefix",
+			Value:    "homeassistant",
+			EnvVars:  []string{"DC_UPDATE_MQTT_DISCOVERY_PREFIX"},
+			Category: notificationsCategory,
+		},
+		&cli.StringFlag{
+			Name:     "mqtt-topic-prefix",
+			Usage:    "Prefix for dc-update's MQTT state and command topics",
+			Value:    "dc-update",
+			EnvVars:  []string{"DC_UPDATE_MQTT_TOPIC_PREFIX"},
+			Category: notificationsCategory,
+		},
+		&cli.BoolFlag{
+			Name:     "mqtt-listen",
+			Usage:    "Keep running, periodically check for updates and install them when requested from Home Assistant",
+			EnvVars:  []string{"DC_UPDATE_MQTT_LISTEN"},
+			Category: notificationsCategory,
+		},
+		&cli.DurationFlag{
+			Name:     "mqtt-check-interval",
+			Usage:    "How often to check for updates with --mqtt-listen",
+			Value:    6 * time.Hour,
+			EnvVars:  []string{"DC_UPDATE_MQTT_CHECK_INTERVAL"},
+			Category: notificationsCategory,
+		},
+	}
+}
+
+// buildNotifiers creates the notifiers enabled by the CLI flags
+func buildNotifiers(cCtx *cli.Context, rep *report.Report) ([]notify.Notifier, error) {
+	var notifiers []notify.Notifier
+
+	if cCtx.String("smtp-host") != "" {
+		smtpNotifier, err := notify.NewSMTPNotifier(notify.SMTPConfig{
+			Host:       cCtx.String("smtp-host"),
+			Port:       cCtx.Int("smtp-port"),
+			Username:   cCtx.String("smtp-username"),
+			Password:   cCtx.String("smtp-password"),
+			From:       cCtx.String("smtp-from"),
+			To:         cCtx.StringSlice("smtp-to"),
+			Security:   cCtx.String("smtp-security"),
+			SkipVerify: cCtx.Bool("smtp-skip-verify"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, smtpNotifier)
+	}
+
+	if cCtx.String("mqtt-broker") != "" {
+		mqttNotifier, err := notify.NewMQTTNotifier(notify.MQTTConfig{
+			Broker:          cCtx.String("mqtt-broker"),
+			Username:        cCtx.String("mqtt-username"),
+			Password:        cCtx.String("mqtt-password"),
+			ClientID:        cCtx.String("mqtt-client-id"),
+			DiscoveryPrefix: cCtx.String("mqtt-discovery-prefix"),
+			TopicPrefix:     cCtx.String("mqtt-topic-prefix"),
+			Hostname:        rep.Hostname,
+			Project:         rep.Project,
+			Version:         version,
+			Listen:          cCtx.Bool("mqtt-listen"),
+		})
+		if err != nil {
+			notify.Close(notifiers)
+			return nil, err
+		}
+		notifiers = append(notifiers, mqttNotifier)
+	} else if cCtx.Bool("mqtt-listen") {
+		return nil, fmt.Errorf("--mqtt-listen requires --mqtt-broker")
+	}
+
+	return notifiers, nil
+}
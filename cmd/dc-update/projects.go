@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dc-update/internal/compose"
+	"dc-update/internal/core"
+	"dc-update/internal/notify"
+	"dc-update/internal/report"
+	"dc-update/internal/schedule"
+
+	"github.com/urfave/cli/v2"
+)
+
+// projectSource is a compose project to update in multi-project mode, or the reason it
+// can't be opened
+type projectSource struct {
+	name   string
+	config compose.Config
+	err    error
+}
+
+// runScan updates every compose project found below root
+func runScan(cCtx *cli.Context, updater *core.UpdaterOptions, cfg *loadedConfig, composeConfig compose.Config, root string) error {
+	composeFiles, err := compose.FindComposeFiles(root, cCtx.StringSlice("scan-ignore"))
+	if err != nil {
+		return err
+	}
+	if len(composeFiles) == 0 {
+		return fmt.Errorf("no compose projects found below %s", root)
+	}
+	fmt.Printf("Found %d compose projects below %s\n", len(composeFiles), root)
+
+	sources := make([]projectSource, 0, len(composeFiles))
+	for _, composeFile := range composeFiles {
+		projectConfig := composeConfig
+		projectConfig.ComposeFile = composeFile
+		sources = append(sources, projectSource{
+			name:   filepath.Base(filepath.Dir(composeFile)),
+			config: projectConfig,
+		})
+	}
+	return runProjects(cCtx, updater, cfg, sources)
+}
+
+// runAllProjects updates every compose project with running containers, using the
+// compose files and project directory recorded in the containers' labels
+func runAllProjects(cCtx *cli.Context, updater *core.UpdaterOptions, cfg *loadedConfig, composeConfig compose.Config) error {
+	composeProjects, err := updater.DockerClient.ListComposeProjects()
+	if err != nil {
+		return err
+	}
+	if len(composeProjects) == 0 {
+		return fmt.Errorf("no running compose projects found")
+	}
+
+	names := make([]string, 0, len(composeProjects))
+	sources := make([]projectSource, 0, len(composeProjects))
+	for _, composeProject := range composeProjects {
+		names = append(names, composeProject.Name)
+		source := projectSource{name: composeProject.Name}
+
+		if len(composeProject.ConfigFiles) == 0 {
+			source.err = fmt.Errorf("its containers don't record which compose files they were created from")
+		}
+		for _, file := range composeProject.ConfigFiles {
+			if _, err := os.Stat(file); err != nil {
+				source.err = fmt.Errorf("compose file %s is not accessible, it must be available at the same path as on the host: %w", file, err)
+				break
+			}
+		}
+		if source.err == nil {
+			source.config = composeConfig
+			source.config.ComposeFile = composeProject.ConfigFiles[0]
+			source.config.ExtraFiles = composeProject.ConfigFiles[1:]
+			source.config.ProjectName = composeProject.Name
+			source.config.ProjectDir = composeProject.WorkingDir
+		}
+		sources = append(sources, source)
+	}
+	fmt.Printf("Found %d running compose projects: %s\n", len(composeProjects), strings.Join(names, ", "))
+
+	return runProjects(cCtx, updater, cfg, sources)
+}
+
+// runProjects updates several compose projects, a few at a time, and prints one
+// combined summary
+func runProjects(cCtx *cli.Context, updater *core.UpdaterOptions, cfg *loadedConfig, sources []projectSource) error {
+	var projects []*core.UpdaterOptions
+	var reports []*report.Report
+	projectDirs := make(map[string]string)
+
+	for _, source := range sources {
+		project, err := openProject(cCtx, updater, cfg, source, projectDirs)
+		if err != nil {
+			failed := report.New(source.name)
+			failed.Err = err
+			failed.Finish()
+			reports = append(reports, failed)
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", source.name, err)
+			continue
+		}
+
+		projectDirs[project.Report.Project] = filepath.Dir(source.config.ComposeFile)
+		projects = append(projects, project)
+		reports = append(reports, project.Report)
+	}
+
+	updateErr := core.UpdateProjects(projects, cCtx.Int("project-concurrency"), func(project *core.UpdaterOptions) {
+		// Each project is notified on its own, like a single-project run
+		notifiers, err := buildNotifiers(cCtx, project.Report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid notification settings: %v\n", err)
+			return
+		}
+		defer notify.Close(notifiers)
+		if err := notify.Dispatch(notifiers, project.Report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	})
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Project < reports[j].Project
+	})
+	fmt.Printf("\n%s", report.CombinedSummary(reports))
+
+	if updateErr != nil {
+		return fmt.Errorf("failed to update projects: %w", updateErr)
+	}
+	if updater.Stop.Err() != nil {
+		return errInterrupted
+	}
+	if len(projects) < len(reports) {
+		return fmt.Errorf("%d of %d projects could not be opened", len(reports)-len(projects), len(reports))
+	}
+	return nil
+}
+
+// openProject opens the project of a source with its own configuration file, refusing
+// project names that are already taken by a project in projectDirs
+func openProject(cCtx *cli.Context, updater *core.UpdaterOptions, cfg *loadedConfig, source projectSource, projectDirs map[string]string) (*core.UpdaterOptions, error) {
+	if source.err != nil {
+		return nil, source.err
+	}
+
+	projectDir := source.config.ProjectDir
+	if projectDir == "" {
+		projectDir = filepath.Dir(source.config.ComposeFile)
+	}
+	settings, err := cfg.forProject(cCtx, projectDir, source.config.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	source.config.Profiles = settings.Profiles
+	windows, err := schedule.ParseWindows(settings.Windows)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := updater.ForProject(source.config)
+	if err != nil {
+		return nil, err
+	}
+	project.Services = cfg.servicesFor(project.Report.Project, settings)
+	project.Windows = windows
+	if err := project.LoadServiceExtensions(); err != nil {
+		return nil, err
+	}
+	if err := project.ValidateServices(); err != nil {
+		return nil, err
+	}
+
+	// Compose would treat both directories as one project and mix up their containers
+	if other, ok := projectDirs[project.Report.Project]; ok {
+		return nil, fmt.Errorf("project name '%s' is also used by %s", project.Report.Project, other)
+	}
+	return project, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"dc-update/internal/core"
+	"dc-update/internal/report"
+	"dc-update/internal/state"
+
+	"github.com/urfave/cli/v2"
+)
+
+// restoreCommand returns the command restoring a service's volumes from a backup
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Restore the volumes of a service from a backup taken by --backup, or list its backups",
+		ArgsUsage: "SERVICE [BACKUP]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "keep-image",
+				Usage: "Start the service on its current image instead of the one the backup was taken with",
+			},
+		},
+		Action: restore,
+	}
+}
+
+// restore restores a backup, or lists the backups of the service if none is given
+func restore(cCtx *cli.Context) error {
+	args, err := argsWithFlags(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: dc-update [options] restore SERVICE [BACKUP] [--keep-image]")
+	}
+	serviceName := args[0]
+	backupID := ""
+	if len(args) == 2 {
+		backupID = args[1]
+	}
+
+	updater, err := openSingleProject(cCtx)
+	if err != nil {
+		return err
+	}
+	defer updater.Close()
+	applyTimeoutSettings(cCtx, updater)
+	applyBackupSettings(cCtx, updater)
+
+	if err := updater.Compose.ValidateServiceExists(serviceName); err != nil {
+		return err
+	}
+
+	if backupID == "" {
+		return listBackups(updater, serviceName)
+	}
+
+	restored, err := updater.RestoreBackup(serviceName, backupID, cCtx.Bool("keep-image"))
+	if err != nil {
+		return err
+	}
+	backup := restored.Backup
+	if !restored.RolledBack {
+		return nil
+	}
+	fmt.Printf("%s runs %s (%s) again, the image the backup was taken with.\n", serviceName, backup.Image, report.ShortID(backup.ImageID))
+	if restored.ReplacedImageID == "" {
+		return nil
+	}
+
+	// Keep the next run from installing the image that was rolled back again
+	entry := state.Freeze{
+		Project:   updater.Compose.ProjectName(),
+		Service:   serviceName,
+		Digest:    report.ShortID(restored.ReplacedImageID),
+		Reason:    "rolled back to backup " + backup.ID,
+		CreatedAt: time.Now(),
+	}
+	path := cCtx.String("state-file")
+	st, err := state.Load(path)
+	if err != nil {
+		return fmt.Errorf("rolled back %s but failed to skip %s in later runs: %w", serviceName, report.ShortID(restored.ReplacedImageID), err)
+	}
+	st.PruneFreezes(entry.CreatedAt)
+	st.AddFreeze(entry)
+	if err := st.Save(path); err != nil {
+		return fmt.Errorf("rolled back %s but failed to skip %s in later runs: %w", serviceName, report.ShortID(restored.ReplacedImageID), err)
+	}
+	fmt.Printf("🧊 %s/%s: %s, `dc-update unfreeze %s` installs it again\n", entry.Project, serviceName, entry, serviceName)
+	return nil
+}
+
+// listBackups prints the backups of a service, newest first
+func listBackups(updater *core.UpdaterOptions, serviceName string) error {
+	backups, err := updater.Backups(serviceName)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Printf("No backups of %s\n", serviceName)
+		return nil
+	}
+
+	fmt.Printf("Backups of %s, newest first:\n", serviceName)
+	for _, backup := range backups {
+		fmt.Printf("  %s  %s (%s)  %d volume(s)\n", backup.ID, backup.Image, report.ShortID(backup.ImageID), len(backup.Volumes))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"dc-update/internal/docker"
+)
+
+// Available backends
+const (
+	// BackendCLI shells out to the docker compose CLI
+	BackendCLI = "cli"
+	// BackendNative loads the project with compose-go and talks to the Docker Engine API directly
+	BackendNative = "native"
+)
+
+// Backend performs the compose operations dc-update needs on a single project. Operations
+// taking a timeout fail once it has passed, a timeout of 0 means no limit.
+type Backend interface {
+	ProjectName() string
+	ProjectDir() string
+	GetServiceNames() ([]string, error)
+	GetCurrentContainerIds(serviceName string) ([]string, error)
+	ValidateServiceExists(serviceName string) error
+	GetServiceImageName(serviceName string) (string, error)
+	StopContainer(serviceName string, timeout time.Duration) error
+	RemoveContainer(serviceName string, timeout time.Duration) error
+	StartContainer(serviceName string, timeout time.Duration) error
+	ScaleService(serviceName string, replicas int, timeout time.Duration) error
+	PullContainer(serviceName string, timeout time.Duration) error
+	PullContainers(serviceNames []string, timeout time.Duration) error
+	BuildContainers(serviceNames []string) error
+	ServiceExtensions(key string) (map[string]interface{}, error)
+}
+
+// BatchTimeoutError is the error of several images pulled together whose pull didn't
+// finish within the timeout they share. Which of them were pulled by then is unknown.
+type BatchTimeoutError struct {
+	Err error
+}
+
+func (e *BatchTimeoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BatchTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Config selects and configures the backend for a compose project
+type Config struct {
+	Backend        string // BackendCLI or BackendNative
+	ComposeFile    string
+	ExtraFiles     []string // further compose files merged on top of ComposeFile, like repeated -f
+	ProjectName    string   // overrides the project name derived from the project directory
+	ProjectDir     string   // overrides the project directory, the directory of ComposeFile by default
+	Profiles       []string // compose profiles to enable, COMPOSE_PROFILES if empty
+	ComposeCommand string   // compose CLI override, e.g. "docker-compose"; detected if empty
+}
+
+// files returns the absolute paths of all compose files of the project
+func (config Config) files() []string {
+	files := make([]string, 0, 1+len(config.ExtraFiles))
+	for _, file := range append([]string{config.ComposeFile}, config.ExtraFiles...) {
+		absPath, _ := filepath.Abs(file)
+		files = append(files, absPath)
+	}
+	return files
+}
+
+// projectDir returns the absolute path of the project directory
+func (config Config) projectDir() string {
+	if config.ProjectDir != "" {
+		absPath, _ := filepath.Abs(config.ProjectDir)
+		return absPath
+	}
+	return filepath.Dir(config.files()[0])
+}
+
+// NewBackend creates the configured backend for a compose file
+func NewBackend(config Config, dockerClient *docker.Client) (Backend, error) {
+	switch config.Backend {
+	case "", BackendCLI:
+		cli, err := DetectCLI(config.ComposeCommand, dockerClient.Engine())
+		if err != nil {
+			return nil, err
+		}
+		// Compose commands must reach the same daemon as the API client, whatever
+		// DOCKER_HOST, DOCKER_CONTEXT or the current docker context say
+		opts := NewOptions(config, cli)
+		opts.Env = dockerClient.Env()
+		opts.Context = dockerClient.Context()
+		return opts, nil
+	case BackendNative:
+		return NewNativeBackend(config, dockerClient)
+	default:
+		return nil, fmt.Errorf("unknown compose backend '%s' (expected cli or native)", config.Backend)
+	}
+}
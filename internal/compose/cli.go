@@ -0,0 +1,88 @@
+package compose
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"dc-update/internal/docker"
+)
+
+// CLI describes the compose command line tool used by the CLI backend
+type CLI struct {
+	Command []string // e.g. ["docker", "compose"] or ["docker-compose"]
+	Version string   // e.g. "2.24.5" or "1.29.2"
+}
+
+// cliCandidates are probed in order when no compose command is configured, per engine
+var cliCandidates = map[string][][]string{
+	docker.EngineDocker: {
+		{"docker", "compose"},
+		{"docker-compose"},
+	},
+	docker.EnginePodman: {
+		{"podman", "compose"},
+		{"podman-compose"},
+	},
+}
+
+// DetectCLI finds the compose CLI to use for an engine and its version. If override
+// is set, e.g. "docker-compose", only that command is probed.
+func DetectCLI(override string, engine string) (*CLI, error) {
+	candidates := cliCandidates[engine]
+	if override != "" {
+		candidates = [][]string{strings.Fields(override)}
+	}
+
+	var lastErr error
+	for _, command := range candidates {
+		version, err := probeVersion(command)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return &CLI{Command: command, Version: version}, nil
+	}
+
+	if override != "" {
+		return nil, fmt.Errorf("compose command '%s' is not usable: %w", override, lastErr)
+	}
+	if engine == docker.EnginePodman {
+		return nil, fmt.Errorf("no compose CLI found, install podman-compose or docker-compose, or use --backend native: %w", lastErr)
+	}
+	return nil, fmt.Errorf("no compose CLI found, install docker compose v2 or docker-compose, or use --backend native: %w", lastErr)
+}
+
+// probeVersion runs `<command> version --short`, which both v1 and v2 support
+func probeVersion(command []string) (string, error) {
+	args := append(append([]string{}, command[1:]...), "version", "--short")
+	output, err := exec.Command(command[0], args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("'%s version' failed: %w", strings.Join(command, " "), err)
+	}
+
+	version := strings.TrimPrefix(strings.TrimSpace(string(output)), "v")
+	if version == "" {
+		return "", fmt.Errorf("'%s version' returned no version", strings.Join(command, " "))
+	}
+	return version, nil
+}
+
+// Major returns the major version, or 0 if it cannot be parsed
+func (c *CLI) Major() int {
+	major, _, _ := strings.Cut(c.Version, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
+
+// IsV1 reports whether this is a 1.x compose CLI, i.e. the legacy python docker-compose
+// or podman-compose, neither of which can render the config as JSON
+func (c *CLI) IsV1() bool {
+	return c.Major() == 1
+}
+
+// String returns the command and version, e.g. "docker compose 2.24.5"
+func (c *CLI) String() string {
+	return fmt.Sprintf("%s %s", strings.Join(c.Command, " "), c.Version)
+}
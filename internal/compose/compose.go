@@ -1,31 +1,187 @@
 package compose
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
+
+	"dc-update/internal/docker"
+	"dc-update/internal/interrupt"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Options holds configuration for docker-compose operations
 type Options struct {
-	ComposeFile string
-	WorkingDir  string
+	ComposeFiles []string // absolute paths, merged in order
+	WorkingDir   string   // project directory
+	Project      string   // explicit project name, resolved by compose if empty
+	Profiles     []string // compose profiles to enable
+	CLI          *CLI
+	Env          []string        // extra environment for compose commands, e.g. DOCKER_HOST
+	Context      context.Context // cancels running compose commands, never if nil
+
+	projectName     string // resolved by compose, see ProjectName
+	projectNameOnce sync.Once
 }
 
-// NewOptions creates docker-compose options from a compose file path
-func NewOptions(composeFilePath string) *Options {
-	absPath, _ := filepath.Abs(composeFilePath)
+// NewOptions creates docker-compose options for the compose files of a project
+func NewOptions(config Config, cli *CLI) *Options {
 	return &Options{
-		ComposeFile: filepath.Base(absPath),
-		WorkingDir:  filepath.Dir(absPath),
+		ComposeFiles: config.files(),
+		WorkingDir:   config.projectDir(),
+		Project:      config.ProjectName,
+		Profiles:     config.Profiles,
+		CLI:          cli,
+	}
+}
+
+// command builds a compose command for the project, e.g. `docker compose -f [file] [args...]`
+func (opts *Options) command(args ...string) *exec.Cmd {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return opts.commandContext(ctx, args...)
+}
+
+// run runs a compose command, killing it once timeout has passed. A timeout of 0 means
+// no limit. Errors include the last line compose wrote to stderr, which usually names the
+// cause, e.g. "toomanyrequests: You have reached your pull rate limit".
+func (opts *Options) run(timeout time.Duration, args ...string) error {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var stderr bytes.Buffer
+	cmd := opts.commandContext(ctx, args...)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &docker.TimeoutError{Limit: timeout}
+	}
+	if err != nil {
+		if line := lastLine(stderr.String()); line != "" {
+			return fmt.Errorf("%w: %s", err, line)
+		}
+	}
+	return err
+}
+
+// lastLine returns the last non-empty line of output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// commandContext builds a compose command that is killed when ctx is done
+func (opts *Options) commandContext(ctx context.Context, args ...string) *exec.Cmd {
+	cmdArgs := append([]string{}, opts.CLI.Command[1:]...)
+	for _, file := range opts.ComposeFiles {
+		cmdArgs = append(cmdArgs, "-f", file)
+	}
+	if opts.Project != "" {
+		cmdArgs = append(cmdArgs, "-p", opts.Project)
+	}
+	for _, profile := range opts.Profiles {
+		cmdArgs = append(cmdArgs, "--profile", profile)
+	}
+	if opts.WorkingDir != filepath.Dir(opts.ComposeFiles[0]) {
+		cmdArgs = append(cmdArgs, "--project-directory", opts.WorkingDir)
+	}
+	cmdArgs = append(cmdArgs, args...)
+
+	cmd := exec.CommandContext(ctx, opts.CLI.Command[0], cmdArgs...)
+	// A Ctrl-C must not reach compose directly, dc-update lets running commands finish
+	// and only cancels them through ctx
+	interrupt.Detach(cmd)
+	cmd.Dir = opts.WorkingDir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
 	}
+	return cmd
+}
+
+// invalidProjectNameChars matches characters docker compose strips from project names
+var invalidProjectNameChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// ProjectName returns the compose project name. It is the one docker compose resolves
+// itself, which takes the top-level name of the compose file and COMPOSE_PROJECT_NAME from
+// the project's .env into account, so dc-update finds the same containers as compose.
+func (opts *Options) ProjectName() string {
+	opts.projectNameOnce.Do(func() {
+		opts.projectName = opts.resolveProjectName()
+	})
+	return opts.projectName
+}
+
+// resolveProjectName reads the project name from `docker compose config`. v1 doesn't
+// render it, nor does a config that can't be rendered, so then it is derived like compose
+// does: the explicit name if set, then COMPOSE_PROJECT_NAME of the environment or the .env
+// file, otherwise the normalized name of the working directory.
+func (opts *Options) resolveProjectName() string {
+	if opts.Project != "" {
+		return opts.Project
+	}
+	if !opts.CLI.IsV1() {
+		if config, err := opts.renderConfig(); err == nil && config.Name != "" {
+			return config.Name
+		}
+	}
+
+	name := os.Getenv("COMPOSE_PROJECT_NAME")
+	if name == "" {
+		name = dotEnvValue(filepath.Join(opts.WorkingDir, ".env"), "COMPOSE_PROJECT_NAME")
+	}
+	if name == "" {
+		name = filepath.Base(opts.WorkingDir)
+	}
+	name = invalidProjectNameChars.ReplaceAllString(strings.ToLower(name), "")
+	if opts.CLI.IsV1() {
+		return name
+	}
+	// docker compose v2 also drops leading dashes and underscores, e.g. "_app" is "app"
+	return strings.TrimLeft(name, "_-")
+}
+
+// dotEnvValue returns the value of a variable set in a .env file, "" if it isn't set there
+func dotEnvValue(path string, key string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.TrimSpace(strings.TrimPrefix(name, "export ")) != key {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value
+	}
+	return ""
 }
 
 // GetServiceNames executes `docker compose config --services` and returns service names
 func (opts *Options) GetServiceNames() ([]string, error) {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "config", "--services")
-	cmd.Dir = opts.WorkingDir
+	cmd := opts.command("config", "--services")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -46,19 +202,24 @@ func (opts *Options) GetServiceNames() ([]string, error) {
 	return services, nil
 }
 
-// GetCurrentContainerId executes `docker compose ps -q [service_name]` and returns container ID
-func (opts *Options) GetCurrentContainerId(serviceName string) (string, error) {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "ps", "-q", serviceName)
-	cmd.Dir = opts.WorkingDir
+// GetCurrentContainerIds executes `docker compose ps -q [service_name]` and returns the IDs
+// of all of the service's containers, one per replica
+func (opts *Options) GetCurrentContainerIds(serviceName string) ([]string, error) {
+	cmd := opts.command("ps", "-q", serviceName)
 
 	output, err := cmd.Output()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Parse output - trim whitespace and return container ID
-	containerID := strings.TrimSpace(string(output))
-	return containerID, nil
+	// Parse output - one container ID per line
+	var containerIDs []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if containerID := strings.TrimSpace(line); containerID != "" {
+			containerIDs = append(containerIDs, containerID)
+		}
+	}
+	return containerIDs, nil
 }
 
 // ValidateServiceExists checks if a service exists in the docker-compose file
@@ -78,56 +239,68 @@ func (opts *Options) ValidateServiceExists(serviceName string) error {
 }
 
 // StopContainer executes `docker compose stop [service]`
-func (opts *Options) StopContainer(serviceName string) error {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "stop", serviceName)
-	cmd.Dir = opts.WorkingDir
-	
-	if err := cmd.Run(); err != nil {
+func (opts *Options) StopContainer(serviceName string, timeout time.Duration) error {
+	if err := opts.run(timeout, "stop", serviceName); err != nil {
 		return fmt.Errorf("failed to stop container '%s': %w", serviceName, err)
 	}
 	return nil
 }
 
 // RemoveContainer executes `docker compose rm [service]`
-func (opts *Options) RemoveContainer(serviceName string) error {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "rm", "-f", serviceName)
-	cmd.Dir = opts.WorkingDir
-	
-	if err := cmd.Run(); err != nil {
+func (opts *Options) RemoveContainer(serviceName string, timeout time.Duration) error {
+	if err := opts.run(timeout, "rm", "-f", serviceName); err != nil {
 		return fmt.Errorf("failed to remove container '%s': %w", serviceName, err)
 	}
 	return nil
 }
 
 // StartContainer executes `docker compose up -d [service]`
-func (opts *Options) StartContainer(serviceName string) error {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "up", "-d", serviceName)
-	cmd.Dir = opts.WorkingDir
-	
-	if err := cmd.Run(); err != nil {
+func (opts *Options) StartContainer(serviceName string, timeout time.Duration) error {
+	if err := opts.run(timeout, "up", "-d", serviceName); err != nil {
 		return fmt.Errorf("failed to start container '%s': %w", serviceName, err)
 	}
 	return nil
 }
 
+// ScaleService executes `docker compose up -d --no-deps --no-recreate --scale [service]=[replicas] [service]`,
+// which starts additional replicas on the current image while leaving existing ones untouched
+func (opts *Options) ScaleService(serviceName string, replicas int, timeout time.Duration) error {
+	err := opts.run(timeout, "up", "-d", "--no-deps", "--no-recreate",
+		"--scale", fmt.Sprintf("%s=%d", serviceName, replicas), serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to scale '%s' to %d replicas: %w", serviceName, replicas, err)
+	}
+	return nil
+}
+
 // PullContainer executes `docker compose pull [service]`
-func (opts *Options) PullContainer(serviceName string) error {
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "pull", serviceName)
-	cmd.Dir = opts.WorkingDir
-	
-	if err := cmd.Run(); err != nil {
+func (opts *Options) PullContainer(serviceName string, timeout time.Duration) error {
+	if err := opts.run(timeout, "pull", serviceName); err != nil {
 		return fmt.Errorf("failed to pull image for '%s': %w", serviceName, err)
 	}
 	return nil
 }
 
+// PullContainers executes `docker compose pull [services...]`, pulling the images of
+// several services in one go. The timeout applies to the pull as a whole.
+func (opts *Options) PullContainers(serviceNames []string, timeout time.Duration) error {
+	args := append([]string{"pull"}, serviceNames...)
+
+	if err := opts.run(timeout, args...); err != nil {
+		var timeoutErr *docker.TimeoutError
+		if len(serviceNames) > 1 && errors.As(err, &timeoutErr) {
+			err = &BatchTimeoutError{Err: err}
+		}
+		return fmt.Errorf("failed to pull images for %v: %w", serviceNames, err)
+	}
+	return nil
+}
+
 // BuildContainers executes `docker compose build --pull [services...]`
 func (opts *Options) BuildContainers(serviceNames []string) error {
-	args := []string{"compose", "-f", opts.ComposeFile, "build", "--pull"}
-	args = append(args, serviceNames...)
+	args := append([]string{"build", "--pull"}, serviceNames...)
 
-	cmd := exec.Command("docker", args...)
-	cmd.Dir = opts.WorkingDir
+	cmd := opts.command(args...)
 	
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to build containers %v: %w", serviceNames, err)
@@ -142,9 +315,13 @@ func (opts *Options) GetServiceImageName(serviceName string) (string, error) {
 		return "", err
 	}
 	
+	// docker compose v2 can render the config as JSON, v1 only as YAML
+	if !opts.CLI.IsV1() {
+		return opts.getServiceImageNameFromJSON(serviceName)
+	}
+
 	// Use docker compose config to get the YAML and parse it
-	cmd := exec.Command("docker", "compose", "-f", opts.ComposeFile, "config")
-	cmd.Dir = opts.WorkingDir
+	cmd := opts.command("config")
 	
 	output, err := cmd.Output()
 	if err != nil {
@@ -188,15 +365,76 @@ func (opts *Options) GetServiceImageName(serviceName string) (string, error) {
 	return "", fmt.Errorf("could not find image for service %s", serviceName)
 }
 
-// RestartContainer is a convenience method that stops, removes, and starts a container
+// getServiceImageNameFromJSON reads the image of a service from `docker compose config --format json`
+func (opts *Options) getServiceImageNameFromJSON(serviceName string) (string, error) {
+	config, err := opts.renderConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if image, ok := config.Services[serviceName]["image"].(string); ok && image != "" {
+		return image, nil
+	}
+	return "", fmt.Errorf("could not find image for service %s", serviceName)
+}
+
+// renderedConfig is the part of the `docker compose config` output dc-update reads
+type renderedConfig struct {
+	Name     string                            `yaml:"name"` // project name, only rendered by v2
+	Services map[string]map[string]interface{} `yaml:"services"`
+}
+
+// renderConfig runs `docker compose config` and parses the resolved project. v2 renders it
+// as JSON, v1 only as YAML, which the YAML parser reads either way.
+func (opts *Options) renderConfig() (*renderedConfig, error) {
+	args := []string{"config"}
+	if !opts.CLI.IsV1() {
+		args = append(args, "--format", "json")
+	}
+	output, err := opts.command(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get docker-compose config: %w", err)
+	}
+
+	var config renderedConfig
+	if err := yaml.Unmarshal(output, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse docker-compose config: %w", err)
+	}
+	return &config, nil
+}
+
+// ServiceExtensions returns the value of an extension field, e.g. x-dc-update, for each
+// service that sets it
+func (opts *Options) ServiceExtensions(key string) (map[string]interface{}, error) {
+	config, err := opts.renderConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := make(map[string]interface{})
+	for name, service := range config.Services {
+		if value, ok := service[key]; ok {
+			extensions[name] = value
+		}
+	}
+	return extensions, nil
+}
+
+// ProjectDir returns the project directory
+func (opts *Options) ProjectDir() string {
+	return opts.WorkingDir
+}
+
+// RestartContainer is a convenience method that stops, removes, and starts a container,
+// without time limits
 func (opts *Options) RestartContainer(serviceName string) error {
-	if err := opts.StopContainer(serviceName); err != nil {
+	if err := opts.StopContainer(serviceName, 0); err != nil {
 		return err
 	}
 
-	if err := opts.RemoveContainer(serviceName); err != nil {
+	if err := opts.RemoveContainer(serviceName, 0); err != nil {
 		return err
 	}
 
-	return opts.StartContainer(serviceName)
+	return opts.StartContainer(serviceName, 0)
 }
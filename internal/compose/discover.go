@@ -0,0 +1,113 @@
+package compose
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileNames are the compose file names docker compose looks for, in order of preference
+var FileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// IgnoreFile lists patterns of directories to skip when scanning, one per line. It is
+// read from the root of the scanned tree.
+const IgnoreFile = ".dc-update-ignore"
+
+// FindComposeFiles walks the tree below root and returns the compose file of every project
+// directory in it. Hidden directories, directories matching an ignore pattern and
+// directories inside a project (e.g. its data volumes) are not scanned. Patterns are
+// matched with filepath.Match against both the directory name and its path relative to root.
+func FindComposeFiles(root string, ignore []string) ([]string, error) {
+	filePatterns, err := readIgnoreFile(filepath.Join(root, IgnoreFile))
+	if err != nil {
+		return nil, err
+	}
+	patterns := append(append([]string{}, ignore...), filePatterns...)
+
+	var composeFiles []string
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// Directories we can't read are skipped rather than failing the whole scan
+			return fs.SkipDir
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != root {
+			rel, _ := filepath.Rel(root, path)
+			if strings.HasPrefix(d.Name(), ".") || ignored(patterns, d.Name(), filepath.ToSlash(rel)) {
+				return fs.SkipDir
+			}
+		}
+
+		if composeFile := findComposeFile(path); composeFile != "" {
+			composeFiles = append(composeFiles, composeFile)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	return composeFiles, nil
+}
+
+// findComposeFile returns the preferred compose file in dir, or "" if there is none
+func findComposeFile(dir string) string {
+	for _, name := range FileNames {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// ignored reports whether a directory matches any of the ignore patterns
+func ignored(patterns []string, name string, rel string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readIgnoreFile reads ignore patterns, skipping blank lines and # comments. A missing
+// file has no patterns.
+func readIgnoreFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return patterns, nil
+}
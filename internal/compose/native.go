@@ -0,0 +1,669 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc-update/internal/docker"
+
+	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/types"
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
+)
+
+// NativeBackend loads the compose project with the compose-go library and performs all
+// operations through the Docker Engine API, so no compose CLI needs to be installed.
+// It creates containers the way docker compose v2 does, labelled so the CLI recognises them.
+type NativeBackend struct {
+	project *types.Project
+	docker  *docker.Client
+}
+
+// NewNativeBackend loads the compose project from its compose files
+func NewNativeBackend(config Config, dockerClient *docker.Client) (*NativeBackend, error) {
+	optionFuncs := []cli.ProjectOptionsFn{
+		cli.WithWorkingDirectory(config.projectDir()),
+		cli.WithOsEnv,
+		cli.WithDotEnv,
+		cli.WithConfigFileEnv,
+	}
+	if len(config.Profiles) > 0 {
+		optionFuncs = append(optionFuncs, cli.WithProfiles(config.Profiles))
+	} else {
+		optionFuncs = append(optionFuncs, cli.WithDefaultProfiles())
+	}
+	if config.ProjectName != "" {
+		optionFuncs = append(optionFuncs, cli.WithName(config.ProjectName))
+	}
+
+	projectOpts, err := cli.NewProjectOptions(config.files(), optionFuncs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure compose project: %w", err)
+	}
+
+	project, err := cli.ProjectFromOptions(dockerClient.Context(), projectOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load compose project: %w", err)
+	}
+
+	return &NativeBackend{
+		project: project,
+		docker:  dockerClient,
+	}, nil
+}
+
+// ProjectName returns the compose project name
+func (b *NativeBackend) ProjectName() string {
+	return b.project.Name
+}
+
+// ProjectDir returns the project directory
+func (b *NativeBackend) ProjectDir() string {
+	return b.project.WorkingDir
+}
+
+// ServiceExtensions returns the value of an extension field, e.g. x-dc-update, for each
+// service that sets it
+func (b *NativeBackend) ServiceExtensions(key string) (map[string]interface{}, error) {
+	extensions := make(map[string]interface{})
+	for name, service := range b.project.Services {
+		if value, ok := service.Extensions[key]; ok {
+			extensions[name] = value
+		}
+	}
+	return extensions, nil
+}
+
+// GetServiceNames returns the names of all services in the project
+func (b *NativeBackend) GetServiceNames() ([]string, error) {
+	names := b.project.ServiceNames()
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetCurrentContainerIds returns the IDs of the service's running containers
+func (b *NativeBackend) GetCurrentContainerIds(serviceName string) ([]string, error) {
+	containers, err := b.docker.ListServiceContainers(b.project.Name, serviceName, false)
+	if err != nil {
+		return nil, err
+	}
+
+	containerIDs := make([]string, 0, len(containers))
+	for _, c := range containers {
+		containerIDs = append(containerIDs, c.ID)
+	}
+	return containerIDs, nil
+}
+
+// ValidateServiceExists checks if a service exists in the compose project
+func (b *NativeBackend) ValidateServiceExists(serviceName string) error {
+	service, err := b.project.GetService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service '%s' does not exist in docker-compose file", serviceName)
+	}
+	if keys := unsupportedSettings(service); len(keys) > 0 {
+		return fmt.Errorf("service '%s' uses %s, which the native backend does not support, use --backend cli",
+			serviceName, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// GetServiceImageName returns the image of a service, or the name docker compose gives
+// images it builds
+func (b *NativeBackend) GetServiceImageName(serviceName string) (string, error) {
+	service, err := b.project.GetService(serviceName)
+	if err != nil {
+		return "", fmt.Errorf("service '%s' does not exist in docker-compose file", serviceName)
+	}
+	return b.imageName(service)
+}
+
+// imageName returns the image a service's containers are created from
+func (b *NativeBackend) imageName(service types.ServiceConfig) (string, error) {
+	if service.Image != "" {
+		return service.Image, nil
+	}
+	if service.Build != nil {
+		return fmt.Sprintf("%s-%s", b.project.Name, service.Name), nil
+	}
+	return "", fmt.Errorf("could not find image for service %s", service.Name)
+}
+
+// StopContainer stops all containers of a service
+func (b *NativeBackend) StopContainer(serviceName string, timeout time.Duration) error {
+	client, cancel := b.docker.WithTimeout(timeout)
+	defer cancel()
+
+	containers, err := client.ListServiceContainers(b.project.Name, serviceName, false)
+	if err != nil {
+		return client.TimeoutErr(err)
+	}
+
+	for _, c := range containers {
+		if err := client.StopContainer(c.ID); err != nil {
+			return fmt.Errorf("failed to stop container '%s': %w", serviceName, client.TimeoutErr(err))
+		}
+	}
+	return nil
+}
+
+// RemoveContainer removes all stopped containers of a service
+func (b *NativeBackend) RemoveContainer(serviceName string, timeout time.Duration) error {
+	client, cancel := b.docker.WithTimeout(timeout)
+	defer cancel()
+
+	containers, err := client.ListServiceContainers(b.project.Name, serviceName, true)
+	if err != nil {
+		return client.TimeoutErr(err)
+	}
+
+	for _, c := range containers {
+		if c.State == "running" {
+			continue
+		}
+		if err := client.RemoveContainer(c.ID); err != nil {
+			return fmt.Errorf("failed to remove container '%s': %w", serviceName, client.TimeoutErr(err))
+		}
+	}
+	return nil
+}
+
+// StartContainer starts the service with its configured number of replicas. Unlike
+// `docker compose up`, dependencies are not started.
+func (b *NativeBackend) StartContainer(serviceName string, timeout time.Duration) error {
+	service, err := b.project.GetService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service '%s' does not exist in docker-compose file", serviceName)
+	}
+
+	client, cancel := b.docker.WithTimeout(timeout)
+	defer cancel()
+	if err := b.scaleUp(client, service, service.GetScale()); err != nil {
+		return fmt.Errorf("failed to start container '%s': %w", serviceName, client.TimeoutErr(err))
+	}
+	return nil
+}
+
+// ScaleService starts additional replicas on the current image until the service has
+// the given number of containers, leaving existing ones untouched
+func (b *NativeBackend) ScaleService(serviceName string, replicas int, timeout time.Duration) error {
+	service, err := b.project.GetService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service '%s' does not exist in docker-compose file", serviceName)
+	}
+
+	client, cancel := b.docker.WithTimeout(timeout)
+	defer cancel()
+	if err := b.scaleUp(client, service, replicas); err != nil {
+		return fmt.Errorf("failed to scale '%s' to %d replicas: %w", serviceName, replicas, client.TimeoutErr(err))
+	}
+	return nil
+}
+
+// PullContainer pulls the image of a service through the Engine API
+func (b *NativeBackend) PullContainer(serviceName string, timeout time.Duration) error {
+	return b.PullContainers([]string{serviceName}, timeout)
+}
+
+// PullContainers pulls the images of several services through the Engine API, pulling
+// images shared by several services once. The timeout applies to each image on its own,
+// so an image that hangs doesn't use up the time of the others.
+func (b *NativeBackend) PullContainers(serviceNames []string, timeout time.Duration) error {
+	pulled := make(map[string]bool)
+	var errs []error
+	for _, serviceName := range serviceNames {
+		service, err := b.project.GetService(serviceName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service '%s' does not exist in docker-compose file", serviceName))
+			continue
+		}
+		// Services that are only built locally have nothing to pull
+		if service.Image == "" || pulled[service.Image] {
+			continue
+		}
+		pulled[service.Image] = true
+
+		if err := b.pullImage(service.Image, timeout); err != nil {
+			errs = append(errs, fmt.Errorf("failed to pull image for '%s': %w", serviceName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pullImage pulls an image, failing once timeout has passed
+func (b *NativeBackend) pullImage(image string, timeout time.Duration) error {
+	client, cancel := b.docker.WithTimeout(timeout)
+	defer cancel()
+	return client.TimeoutErr(client.PullImage(image))
+}
+
+// BuildContainers is not supported, building requires the compose CLI or BuildKit
+func (b *NativeBackend) BuildContainers(serviceNames []string) error {
+	return fmt.Errorf("building containers %v is not supported by the native backend, use --backend cli", serviceNames)
+}
+
+// scaleUp starts stopped containers and creates new ones until the service has the given
+// number of replicas
+func (b *NativeBackend) scaleUp(client *docker.Client, service types.ServiceConfig, replicas int) error {
+	containers, err := client.ListServiceContainers(b.project.Name, service.Name, true)
+	if err != nil {
+		return err
+	}
+
+	usedNumbers := make(map[int]bool, len(containers))
+	for _, c := range containers {
+		if number, err := strconv.Atoi(c.Labels[docker.LabelComposeNumber]); err == nil {
+			usedNumbers[number] = true
+		}
+		if c.State != "running" {
+			if err := client.StartContainer(c.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(containers) >= replicas {
+		return nil
+	}
+
+	if err := b.ensureResources(client, service); err != nil {
+		return err
+	}
+
+	number := 1
+	for count := len(containers); count < replicas; count++ {
+		for usedNumbers[number] {
+			number++
+		}
+		usedNumbers[number] = true
+
+		if err := b.createContainer(client, service, number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureResources creates the networks and named volumes a service uses
+func (b *NativeBackend) ensureResources(client *docker.Client, service types.ServiceConfig) error {
+	for key := range service.Networks {
+		config := b.project.Networks[key]
+		if bool(config.External) {
+			continue
+		}
+		if err := client.EnsureNetwork(b.networkName(key), dockertypes.NetworkCreate{
+			Driver:     config.Driver,
+			Options:    config.DriverOpts,
+			Internal:   config.Internal,
+			Attachable: config.Attachable,
+			Labels: mergeLabels(config.Labels, map[string]string{
+				docker.LabelComposeProject: b.project.Name,
+				docker.LabelComposeNetwork: key,
+			}),
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, volume := range service.Volumes {
+		if volume.Type != types.VolumeTypeVolume || volume.Source == "" {
+			continue
+		}
+		config, ok := b.project.Volumes[volume.Source]
+		if !ok || bool(config.External) {
+			continue
+		}
+		if err := client.EnsureVolume(volumetypes.VolumeCreateBody{
+			Name:       b.volumeName(volume.Source),
+			Driver:     config.Driver,
+			DriverOpts: config.DriverOpts,
+			Labels: mergeLabels(config.Labels, map[string]string{
+				docker.LabelComposeProject: b.project.Name,
+				docker.LabelComposeVolume:  volume.Source,
+			}),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// networkName resolves a project network key to the engine network name
+func (b *NativeBackend) networkName(key string) string {
+	if config, ok := b.project.Networks[key]; ok && config.Name != "" {
+		return config.Name
+	}
+	return fmt.Sprintf("%s_%s", b.project.Name, key)
+}
+
+// volumeName resolves a project volume key to the engine volume name
+func (b *NativeBackend) volumeName(key string) string {
+	if config, ok := b.project.Volumes[key]; ok && config.Name != "" {
+		return config.Name
+	}
+	return fmt.Sprintf("%s_%s", b.project.Name, key)
+}
+
+// createContainer creates and starts replica number of a service
+func (b *NativeBackend) createContainer(client *docker.Client, service types.ServiceConfig, number int) error {
+	image, err := b.imageName(service)
+	if err != nil {
+		return err
+	}
+
+	name := service.ContainerName
+	if name == "" {
+		name = fmt.Sprintf("%s-%s-%d", b.project.Name, service.Name, number)
+	}
+
+	exposed, portBindings, err := portConfig(service.Ports)
+	if err != nil {
+		return err
+	}
+	if err := exposedPorts(exposed, service.Expose); err != nil {
+		return err
+	}
+	hash, err := configHash(service)
+	if err != nil {
+		return err
+	}
+
+	config := &container.Config{
+		Image:        image,
+		Cmd:          []string(service.Command),
+		Entrypoint:   []string(service.Entrypoint),
+		Env:          environment(service.Environment),
+		Hostname:     service.Hostname,
+		Domainname:   service.DomainName,
+		MacAddress:   service.MacAddress,
+		User:         service.User,
+		WorkingDir:   service.WorkingDir,
+		Tty:          service.Tty,
+		OpenStdin:    service.StdinOpen,
+		StopSignal:   service.StopSignal,
+		StopTimeout:  stopTimeout(service),
+		ExposedPorts: exposed,
+		Healthcheck:  healthConfig(service.HealthCheck),
+		Labels: mergeLabels(service.Labels, map[string]string{
+			docker.LabelComposeProject:     b.project.Name,
+			docker.LabelComposeService:     service.Name,
+			docker.LabelComposeNumber:      strconv.Itoa(number),
+			docker.LabelComposeOneOff:      "False",
+			docker.LabelComposeWorkingDir:  b.project.WorkingDir,
+			docker.LabelComposeConfigFiles: strings.Join(b.project.ComposeFiles, ","),
+			docker.LabelComposeConfigHash:  hash,
+		}),
+	}
+
+	networkMode, err := b.sharedMode(client, "network_mode", service.NetworkMode)
+	if err != nil {
+		return err
+	}
+	ipcMode, err := b.sharedMode(client, "ipc", service.Ipc)
+	if err != nil {
+		return err
+	}
+	pidMode, err := b.sharedMode(client, "pid", service.Pid)
+	if err != nil {
+		return err
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings:   portBindings,
+		Binds:          binds(service.Volumes),
+		Mounts:         b.mounts(service.Volumes),
+		LogConfig:      logConfig(service),
+		RestartPolicy:  restartPolicy(service.Restart),
+		Privileged:     service.Privileged,
+		CapAdd:         service.CapAdd,
+		CapDrop:        service.CapDrop,
+		ExtraHosts:     service.ExtraHosts.AsList(":"),
+		DNS:            service.DNS,
+		DNSOptions:     service.DNSOpts,
+		DNSSearch:      service.DNSSearch,
+		GroupAdd:       service.GroupAdd,
+		IpcMode:        container.IpcMode(ipcMode),
+		PidMode:        container.PidMode(pidMode),
+		UTSMode:        container.UTSMode(service.Uts),
+		UsernsMode:     container.UsernsMode(service.UserNSMode),
+		OomScoreAdj:    int(service.OomScoreAdj),
+		ShmSize:        int64(service.ShmSize),
+		Sysctls:        service.Sysctls,
+		Runtime:        service.Runtime,
+		Init:           service.Init,
+		ReadonlyRootfs: service.ReadOnly,
+		SecurityOpt:    service.SecurityOpt,
+		Tmpfs:          tmpfs(service.Tmpfs),
+		Resources:      resources(service),
+	}
+
+	// The first network is attached at creation, the others are connected afterwards
+	networkKeys := make([]string, 0, len(service.Networks))
+	for key := range service.Networks {
+		networkKeys = append(networkKeys, key)
+	}
+	sort.Slice(networkKeys, func(i, j int) bool {
+		return networkPriority(service, networkKeys[i]) > networkPriority(service, networkKeys[j])
+	})
+
+	var networkingConfig *network.NetworkingConfig
+	if service.NetworkMode != "" {
+		hostConfig.NetworkMode = container.NetworkMode(networkMode)
+	} else if len(networkKeys) > 0 {
+		primary := b.networkName(networkKeys[0])
+		hostConfig.NetworkMode = container.NetworkMode(primary)
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				primary: endpointSettings(service, networkKeys[0]),
+			},
+		}
+	}
+
+	containerID, err := client.CreateContainer(name, config, hostConfig, networkingConfig)
+	if err != nil {
+		return err
+	}
+
+	if service.NetworkMode == "" && len(networkKeys) > 1 {
+		for _, key := range networkKeys[1:] {
+			if err := client.ConnectNetwork(b.networkName(key), containerID, endpointSettings(service, key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return client.StartContainer(containerID)
+}
+
+// sharedMode resolves a network_mode, ipc or pid setting that joins the namespace of
+// another container to "container:<ID>", like docker compose does. "service:<name>" names
+// a service of the project, whose first running replica is joined, and "container:<name>"
+// any container. Other settings are returned as they are.
+func (b *NativeBackend) sharedMode(client *docker.Client, key string, mode string) (string, error) {
+	kind, name, ok := strings.Cut(mode, ":")
+	if !ok {
+		return mode, nil
+	}
+	switch kind {
+	case "service":
+		containers, err := client.ListServiceContainers(b.project.Name, name, false)
+		if err != nil {
+			return "", fmt.Errorf("%s %s: %w", key, mode, err)
+		}
+		if len(containers) == 0 {
+			return "", fmt.Errorf("%s %s: service %s has no running container to join", key, mode, name)
+		}
+		sort.Slice(containers, func(i, j int) bool {
+			return replicaNumber(containers[i]) < replicaNumber(containers[j])
+		})
+		return "container:" + containers[0].ID, nil
+	case "container":
+		status, err := client.GetContainerStatus(name)
+		if err != nil {
+			return "", fmt.Errorf("%s %s: %w", key, mode, err)
+		}
+		return "container:" + status.ID, nil
+	default:
+		return mode, nil
+	}
+}
+
+// replicaNumber returns the compose replica number of a container, 0 if it has none
+func replicaNumber(c dockertypes.Container) int {
+	number, _ := strconv.Atoi(c.Labels[docker.LabelComposeNumber])
+	return number
+}
+
+// mounts converts service volumes to engine mounts, except those passed as binds
+func (b *NativeBackend) mounts(volumes []types.ServiceVolumeConfig) []mount.Mount {
+	mounts := make([]mount.Mount, 0, len(volumes))
+	for _, volume := range volumes {
+		if isLegacyBind(volume) {
+			continue
+		}
+		m := mount.Mount{
+			Type:     mount.Type(volume.Type),
+			Source:   volume.Source,
+			Target:   volume.Target,
+			ReadOnly: volume.ReadOnly,
+		}
+		if volume.Type == types.VolumeTypeVolume && volume.Source != "" {
+			m.Source = b.volumeName(volume.Source)
+		}
+		if volume.Bind != nil && volume.Bind.Propagation != "" {
+			m.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(volume.Bind.Propagation)}
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts
+}
+
+// networkPriority returns the priority of a service network, higher is attached first
+func networkPriority(service types.ServiceConfig, key string) int {
+	if config := service.Networks[key]; config != nil {
+		return config.Priority
+	}
+	return 0
+}
+
+// endpointSettings returns the aliases and addresses of a service on a network
+func endpointSettings(service types.ServiceConfig, key string) *network.EndpointSettings {
+	settings := &network.EndpointSettings{Aliases: []string{service.Name}}
+	if config := service.Networks[key]; config != nil {
+		settings.Aliases = append(settings.Aliases, config.Aliases...)
+		if config.Ipv4Address != "" || config.Ipv6Address != "" {
+			settings.IPAMConfig = &network.EndpointIPAMConfig{
+				IPv4Address: config.Ipv4Address,
+				IPv6Address: config.Ipv6Address,
+			}
+		}
+	}
+	return settings
+}
+
+// portConfig converts service ports to exposed ports and host bindings
+func portConfig(ports []types.ServicePortConfig) (nat.PortSet, nat.PortMap, error) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+
+	for _, p := range ports {
+		protocol := p.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		port, err := nat.NewPort(protocol, strconv.FormatUint(uint64(p.Target), 10))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid port %d/%s: %w", p.Target, protocol, err)
+		}
+
+		exposed[port] = struct{}{}
+		if p.Published != "" {
+			bindings[port] = append(bindings[port], nat.PortBinding{HostIP: p.HostIP, HostPort: p.Published})
+		}
+	}
+
+	return exposed, bindings, nil
+}
+
+// environment converts a compose environment mapping to KEY=value pairs
+func environment(env types.MappingWithEquals) []string {
+	vars := make([]string, 0, len(env))
+	for key, value := range env {
+		if value != nil {
+			vars = append(vars, fmt.Sprintf("%s=%s", key, *value))
+		}
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// restartPolicy parses a compose restart value like "unless-stopped" or "on-failure:3"
+func restartPolicy(restart string) container.RestartPolicy {
+	name, count, _ := strings.Cut(restart, ":")
+	policy := container.RestartPolicy{Name: name}
+	if maxRetries, err := strconv.Atoi(count); err == nil {
+		policy.MaximumRetryCount = maxRetries
+	}
+	return policy
+}
+
+// healthConfig converts a compose healthcheck to the engine format
+func healthConfig(health *types.HealthCheckConfig) *container.HealthConfig {
+	if health == nil {
+		return nil
+	}
+	if health.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}
+	}
+
+	config := &container.HealthConfig{Test: health.Test}
+	if health.Interval != nil {
+		config.Interval = time.Duration(*health.Interval)
+	}
+	if health.Timeout != nil {
+		config.Timeout = time.Duration(*health.Timeout)
+	}
+	if health.StartPeriod != nil {
+		config.StartPeriod = time.Duration(*health.StartPeriod)
+	}
+	if health.Retries != nil {
+		config.Retries = int(*health.Retries)
+	}
+	return config
+}
+
+// tmpfs converts compose tmpfs entries like "/run:size=64m" to engine mounts
+func tmpfs(entries types.StringList) map[string]string {
+	if len(entries) == 0 {
+		return nil
+	}
+	mounts := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		path, options, _ := strings.Cut(entry, ":")
+		mounts[path] = options
+	}
+	return mounts
+}
+
+// mergeLabels combines user labels with the labels compose requires
+func mergeLabels(labels map[string]string, required map[string]string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(required))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range required {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,250 @@
+package compose
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+)
+
+// unsupportedSettings returns the compose keys a service sets that the native backend
+// cannot apply. Containers created without them would run differently from those
+// docker compose creates, so such services are refused.
+func unsupportedSettings(service types.ServiceConfig) []string {
+	var keys []string
+	check := func(key string, set bool) {
+		if set {
+			keys = append(keys, key)
+		}
+	}
+	check("annotations", len(service.Annotations) > 0)
+	check("blkio_config", service.BlkioConfig != nil)
+	check("cgroup", service.Cgroup != "")
+	check("configs", len(service.Configs) > 0)
+	check("cpu_count", service.CPUCount != 0)
+	check("cpu_percent", service.CPUPercent != 0)
+	check("cpu_rt_period", service.CPURTPeriod != 0)
+	check("cpu_rt_runtime", service.CPURTRuntime != 0)
+	check("credential_spec", service.CredentialSpec != nil)
+	check("external_links", len(service.ExternalLinks) > 0)
+	check("gpus", len(service.Gpus) > 0)
+	check("isolation", service.Isolation != "")
+	check("links", len(service.Links) > 0)
+	check("mem_swappiness", service.MemSwappiness != 0)
+	check("post_start", len(service.PostStart) > 0)
+	check("pre_stop", len(service.PreStop) > 0)
+	check("secrets", len(service.Secrets) > 0)
+	check("storage_opt", len(service.StorageOpt) > 0)
+	check("volume_driver", service.VolumeDriver != "")
+	check("volumes_from", len(service.VolumesFrom) > 0)
+	if service.Deploy != nil {
+		for _, resource := range []*types.Resource{service.Deploy.Resources.Limits, service.Deploy.Resources.Reservations} {
+			if resource == nil {
+				continue
+			}
+			check("deploy.resources devices", len(resource.Devices) > 0)
+			check("deploy.resources generic_resources", len(resource.GenericResources) > 0)
+		}
+	}
+	return keys
+}
+
+// configHash returns the hash docker compose v2 stores in the config-hash label: the
+// SHA-256 of the service configuration as JSON, without the settings that don't require
+// recreating the containers
+func configHash(service types.ServiceConfig) (string, error) {
+	service.Build = nil
+	service.PullPolicy = ""
+	service.Scale = nil
+	if service.Deploy != nil {
+		// Copied, the project's configuration must not change
+		deploy := *service.Deploy
+		var replicas int
+		deploy.Replicas = &replicas
+		service.Deploy = &deploy
+	}
+	service.DependsOn = nil
+	service.Profiles = nil
+
+	data, err := json.Marshal(service)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash the configuration of service %s: %w", service.Name, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// exposedPorts adds the ports of the expose key, e.g. "3000" or "8000-8010/udp"
+func exposedPorts(exposed nat.PortSet, expose types.StringOrNumberList) error {
+	if len(expose) == 0 {
+		return nil
+	}
+	ports, _, err := nat.ParsePortSpecs(expose)
+	if err != nil {
+		return fmt.Errorf("invalid expose %v: %w", []string(expose), err)
+	}
+	for port := range ports {
+		exposed[port] = struct{}{}
+	}
+	return nil
+}
+
+// resources converts the resource limits of a service, set directly or under
+// deploy.resources, to the engine format. deploy.resources takes precedence like with
+// docker compose.
+func resources(service types.ServiceConfig) container.Resources {
+	r := container.Resources{
+		CgroupParent:      service.CgroupParent,
+		CPUShares:         service.CPUShares,
+		CPUPeriod:         service.CPUPeriod,
+		CPUQuota:          service.CPUQuota,
+		CpusetCpus:        service.CPUSet,
+		NanoCPUs:          int64(float64(service.CPUS) * 1e9),
+		Memory:            int64(service.MemLimit),
+		MemoryReservation: int64(service.MemReservation),
+		MemorySwap:        int64(service.MemSwapLimit),
+		Devices:           devices(service.Devices),
+		DeviceCgroupRules: service.DeviceCgroupRules,
+		Ulimits:           ulimits(service.Ulimits),
+	}
+	if service.OomKillDisable {
+		r.OomKillDisable = &service.OomKillDisable
+	}
+	if service.PidsLimit != 0 {
+		r.PidsLimit = &service.PidsLimit
+	}
+
+	if service.Deploy == nil {
+		return r
+	}
+	if limits := service.Deploy.Resources.Limits; limits != nil {
+		if limits.NanoCPUs != 0 {
+			r.NanoCPUs = int64(float64(limits.NanoCPUs) * 1e9)
+		}
+		if limits.MemoryBytes != 0 {
+			r.Memory = int64(limits.MemoryBytes)
+		}
+		if limits.Pids != 0 {
+			r.PidsLimit = &limits.Pids
+		}
+	}
+	if reservations := service.Deploy.Resources.Reservations; reservations != nil && reservations.MemoryBytes != 0 {
+		r.MemoryReservation = int64(reservations.MemoryBytes)
+	}
+	return r
+}
+
+// devices converts device mappings like "/dev/ttyUSB0:/dev/ttyUSB0:rwm"
+func devices(mappings []types.DeviceMapping) []container.DeviceMapping {
+	if len(mappings) == 0 {
+		return nil
+	}
+	converted := make([]container.DeviceMapping, 0, len(mappings))
+	for _, m := range mappings {
+		device := container.DeviceMapping{
+			PathOnHost:        m.Source,
+			PathInContainer:   m.Target,
+			CgroupPermissions: m.Permissions,
+		}
+		if device.PathInContainer == "" {
+			device.PathInContainer = m.Source
+		}
+		if device.CgroupPermissions == "" {
+			device.CgroupPermissions = "rwm"
+		}
+		converted = append(converted, device)
+	}
+	return converted
+}
+
+// ulimits converts ulimits, a single value sets the soft and the hard limit
+func ulimits(limits map[string]*types.UlimitsConfig) []*units.Ulimit {
+	if len(limits) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	converted := make([]*units.Ulimit, 0, len(limits))
+	for _, name := range names {
+		limit := limits[name]
+		if limit == nil {
+			continue
+		}
+		soft, hard := limit.Soft, limit.Hard
+		if limit.Single != 0 {
+			soft, hard = limit.Single, limit.Single
+		}
+		converted = append(converted, &units.Ulimit{Name: name, Soft: int64(soft), Hard: int64(hard)})
+	}
+	return converted
+}
+
+// logConfig returns the logging driver of a service, from logging or the older log_driver
+// and log_opt keys
+func logConfig(service types.ServiceConfig) container.LogConfig {
+	config := container.LogConfig{Type: service.LogDriver, Config: service.LogOpt}
+	if service.Logging != nil {
+		if service.Logging.Driver != "" {
+			config.Type = service.Logging.Driver
+		}
+		if len(service.Logging.Options) > 0 {
+			config.Config = service.Logging.Options
+		}
+	}
+	return config
+}
+
+// stopTimeout returns the stop_grace_period of a service in seconds, nil if it has none
+func stopTimeout(service types.ServiceConfig) *int {
+	if service.StopGracePeriod == nil {
+		return nil
+	}
+	seconds := int(time.Duration(*service.StopGracePeriod).Seconds())
+	return &seconds
+}
+
+// binds returns the bind mounts of the short "./data:/data" syntax, and those asking for
+// SELinux relabeling, in the legacy binds format. Unlike mounts, the engine creates
+// missing source directories of binds on its own host, like docker compose relies on.
+func binds(volumes []types.ServiceVolumeConfig) []string {
+	var specs []string
+	for _, volume := range volumes {
+		if !isLegacyBind(volume) {
+			continue
+		}
+		var options []string
+		if volume.ReadOnly {
+			options = append(options, "ro")
+		}
+		if volume.Bind.SELinux != "" {
+			options = append(options, volume.Bind.SELinux)
+		}
+		if volume.Bind.Propagation != "" {
+			options = append(options, volume.Bind.Propagation)
+		}
+		spec := volume.Source + ":" + volume.Target
+		if len(options) > 0 {
+			spec += ":" + strings.Join(options, ",")
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// isLegacyBind reports whether a volume is passed as a bind instead of a mount
+func isLegacyBind(volume types.ServiceVolumeConfig) bool {
+	return volume.Type == types.VolumeTypeBind && volume.Bind != nil &&
+		(volume.Bind.CreateHostPath || volume.Bind.SELinux != "")
+}
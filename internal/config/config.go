@@ -0,0 +1,389 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFileName is the name of the project configuration file, next to the compose file
+const ProjectFileName = ".dc-update.yaml"
+
+// File is a parsed dc-update configuration file. Settings use the names of the command
+// line flags, e.g. "show-warnings", with nested sections joined by dashes, so
+//
+//	notifications:
+//	  smtp:
+//	    host: mail.example.com
+//
+// sets "smtp-host". Underscores in names are treated as dashes.
+type File struct {
+	Path     string
+	Settings map[string][]string // flag name to values, lists have one value per item
+	Services map[string]Service  // per-service overrides by service name
+	// Registries holds credentials by registry domain, e.g. "ghcr.io"
+	Registries map[string]Registry
+}
+
+// Service overrides settings for a single service. Zero values keep the global setting.
+type Service struct {
+	Skip               bool              `yaml:"skip"`  // never update the service
+	Build              bool              `yaml:"build"` // build the service before updating
+	Strategy           string            `yaml:"strategy"`
+	Prune              *bool             `yaml:"prune"`
+	Backup             *bool             `yaml:"backup"` // archive the service's volumes before updating it
+	RollingBatchSize   int               `yaml:"rolling_batch_size"`
+	HealthTimeout      time.Duration     `yaml:"health_timeout"`
+	MinAge             time.Duration     `yaml:"min_age"`             // how old a new image must be before it is installed
+	PullTimeout        time.Duration     `yaml:"pull_timeout"`
+	StopTimeout        time.Duration     `yaml:"stop_timeout"`
+	StartTimeout       time.Duration     `yaml:"start_timeout"`
+	Hooks              map[string][]Hook `yaml:"hooks"`               // hooks by event, e.g. "pre-restart"
+	MaintenanceWindows []string          `yaml:"maintenance_windows"` // replace the project's windows
+}
+
+// Registry holds the credentials dc-update uses for a registry instead of those of the
+// docker CLI configuration. At most one of Password and PasswordFile is set.
+type Registry struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"` // file holding the password, e.g. a mounted secret
+}
+
+// ReadPassword returns the password, read from PasswordFile if that is set
+func (r Registry) ReadPassword() (string, error) {
+	if r.PasswordFile == "" {
+		return r.Password, nil
+	}
+	data, err := os.ReadFile(r.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Hook is a command run at a point of a service's update, either on the host or inside
+// a container. Exactly one of Command and Exec is set.
+type Hook struct {
+	Command string        `yaml:"command"` // shell command run on the host in the project directory
+	Exec    string        `yaml:"exec"`    // shell command run inside a container of the service
+	Service string        `yaml:"service"` // service to exec in, the hooked service by default
+	User    string        `yaml:"user"`    // user to exec as
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// String describes the hook, e.g. "exec in db: pg_dump app"
+func (h Hook) String() string {
+	if h.Exec != "" {
+		if h.Service != "" {
+			return fmt.Sprintf("exec in %s: %s", h.Service, h.Exec)
+		}
+		return "exec: " + h.Exec
+	}
+	return h.Command
+}
+
+// Merge returns s with the settings set in override replacing its own. Hooks are
+// replaced per event.
+func (s Service) Merge(override Service) Service {
+	merged := s
+	if override.Skip {
+		merged.Skip = true
+	}
+	if override.Build {
+		merged.Build = true
+	}
+	if override.Strategy != "" {
+		merged.Strategy = override.Strategy
+	}
+	if override.Prune != nil {
+		merged.Prune = override.Prune
+	}
+	if override.Backup != nil {
+		merged.Backup = override.Backup
+	}
+	if len(override.MaintenanceWindows) > 0 {
+		merged.MaintenanceWindows = override.MaintenanceWindows
+	}
+	if override.RollingBatchSize > 0 {
+		merged.RollingBatchSize = override.RollingBatchSize
+	}
+	if override.HealthTimeout > 0 {
+		merged.HealthTimeout = override.HealthTimeout
+	}
+	if override.MinAge > 0 {
+		merged.MinAge = override.MinAge
+	}
+	if override.PullTimeout > 0 {
+		merged.PullTimeout = override.PullTimeout
+	}
+	if override.StopTimeout > 0 {
+		merged.StopTimeout = override.StopTimeout
+	}
+	if override.StartTimeout > 0 {
+		merged.StartTimeout = override.StartTimeout
+	}
+	if len(override.Hooks) > 0 {
+		merged.Hooks = make(map[string][]Hook, len(s.Hooks)+len(override.Hooks))
+		for event, hooks := range s.Hooks {
+			merged.Hooks[event] = hooks
+		}
+		for event, hooks := range override.Hooks {
+			merged.Hooks[event] = hooks
+		}
+	}
+	return merged
+}
+
+// ProjectServices returns the overrides that apply to the services of a project. An
+// override named "<project>/<service>" only applies to that project's service and is
+// merged over one named by the service alone, which applies to the service of every project.
+func ProjectServices(services map[string]Service, project string) map[string]Service {
+	resolved := make(map[string]Service, len(services))
+	for name, service := range services {
+		if !strings.Contains(name, "/") {
+			resolved[name] = service
+		}
+	}
+	for name, service := range services {
+		scope, serviceName, scoped := strings.Cut(name, "/")
+		if scoped && scope == project {
+			resolved[serviceName] = resolved[serviceName].Merge(service)
+		}
+	}
+	return resolved
+}
+
+// transparentSections are sections whose name is not part of the setting names
+var transparentSections = map[string]bool{
+	"notifications": true,
+}
+
+// GlobalPath returns the path of the global configuration file,
+// e.g. ~/.config/dc-update/config.yaml
+func GlobalPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "dc-update", "config.yaml")
+}
+
+// BackupDir returns the default directory for volume backups,
+// e.g. ~/.local/share/dc-update/backups
+func BackupDir() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "backups"
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "dc-update", "backups")
+}
+
+// StatePath returns the path of the file dc-update keeps its state in, e.g. freezes,
+// e.g. ~/.local/state/dc-update/state.json
+func StatePath() string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "dc-update-state.json"
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "dc-update", "state.json")
+}
+
+// ProjectPath returns the path of the project configuration file in dir
+func ProjectPath(dir string) string {
+	return filepath.Join(dir, ProjectFileName)
+}
+
+// Load reads a configuration file. A missing file is not an error and returns nil.
+func Load(path string) (*File, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	file, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	file.Path = path
+	return file, nil
+}
+
+// Parse parses the contents of a configuration file
+func Parse(data []byte) (*File, error) {
+	file := &File{
+		Settings:   make(map[string][]string),
+		Services:   make(map[string]Service),
+		Registries: make(map[string]Registry),
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return file, nil // empty file
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a mapping of settings at line %d", doc.Line)
+	}
+
+	for i := 0; i < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		if key.Value == "services" {
+			if err := decodeServices(value, file.Services); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if key.Value == "registries" {
+			if err := decodeRegistries(value, file.Registries); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := flatten(normalize(key.Value), value, file.Settings); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// decodeServices decodes the per-service overrides, rejecting unknown fields
+func decodeServices(node *yaml.Node, services map[string]Service) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("services: expected a mapping of service names at line %d", node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, node.Content[i+1]
+
+		service, err := decodeService(value)
+		if err != nil {
+			return fmt.Errorf("services.%s: %w", name, err)
+		}
+		services[name] = service
+	}
+	return nil
+}
+
+// decodeRegistries decodes the registry credentials, rejecting unknown fields
+func decodeRegistries(node *yaml.Node, registries map[string]Registry) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("registries: expected a mapping of registry domains at line %d", node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		domain, value := node.Content[i].Value, node.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			for j := 0; j < len(value.Content); j += 2 {
+				value.Content[j].Value = strings.ReplaceAll(value.Content[j].Value, "-", "_")
+			}
+		}
+
+		var registry Registry
+		if err := decodeStrict(value, &registry); err != nil {
+			return fmt.Errorf("registries.%s: %w", domain, err)
+		}
+		if registry.Password != "" && registry.PasswordFile != "" {
+			return fmt.Errorf("registries.%s: password and password_file cannot be used together", domain)
+		}
+		registries[domain] = registry
+	}
+	return nil
+}
+
+// DecodeService decodes per-service overrides from another source, e.g. the
+// x-dc-update extension of a compose service
+func DecodeService(value interface{}) (Service, error) {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return Service{}, err
+	}
+	return decodeService(&node)
+}
+
+// decodeService decodes per-service overrides, rejecting unknown fields
+func decodeService(node *yaml.Node) (Service, error) {
+	// Accept dashes like the other settings, e.g. "health-timeout", but keep hook event
+	// names such as "pre-restart" as they are
+	if node.Kind == yaml.MappingNode {
+		for j := 0; j < len(node.Content); j += 2 {
+			node.Content[j].Value = strings.ReplaceAll(node.Content[j].Value, "-", "_")
+		}
+	}
+
+	var service Service
+	if err := decodeStrict(node, &service); err != nil {
+		return Service{}, err
+	}
+	return service, nil
+}
+
+// decodeStrict decodes a node into out, failing on fields out doesn't have
+func decodeStrict(node *yaml.Node, out interface{}) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
+}
+
+// flatten turns a setting into flag names and values. Nested mappings are joined with
+// dashes, sequences become one value per item.
+func flatten(name string, node *yaml.Node, settings map[string][]string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		settings[name] = []string{node.Value}
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("%s: expected a list of values at line %d", name, item.Line)
+			}
+			values = append(values, item.Value)
+		}
+		settings[name] = values
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := normalize(node.Content[i].Value), node.Content[i+1]
+			if !transparentSections[name] {
+				key = name + "-" + key
+			}
+			if err := flatten(key, value, settings); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported value at line %d", name, node.Line)
+	}
+	return nil
+}
+
+// normalize turns a setting name into flag form, e.g. "show_warnings" into "show-warnings"
+func normalize(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name       string
+		data       string
+		settings   map[string][]string
+		services   map[string]Service
+		registries map[string]Registry
+		wantErr    bool
+	}{
+		{name: "empty file"},
+		{
+			name:     "scalars",
+			data:     "strategy: rolling\nprune_keep: 3\nShow_Warnings: true\n",
+			settings: map[string][]string{"strategy": {"rolling"}, "prune-keep": {"3"}, "show-warnings": {"true"}},
+		},
+		{
+			name:     "lists",
+			data:     "profiles: [web, worker]\nmaintenance-windows:\n  - Sun 02:00-05:00\n",
+			settings: map[string][]string{"profiles": {"web", "worker"}, "maintenance-windows": {"Sun 02:00-05:00"}},
+		},
+		{
+			name:     "nested sections",
+			data:     "notifications:\n  smtp:\n    host: mail.example.com\n    to: [ops@example.com]\n  webhook_url: https://example.com/hook\nbackup:\n  keep: 5\n",
+			settings: map[string][]string{"smtp-host": {"mail.example.com"}, "smtp-to": {"ops@example.com"}, "webhook-url": {"https://example.com/hook"}, "backup-keep": {"5"}},
+		},
+		{
+			name: "services",
+			data: "services:\n  web:\n    strategy: rolling\n    health-timeout: 2m\n    prune: true\n    hooks:\n      pre-restart:\n        - exec: pg_dump app\n          service: db\n  blog/worker:\n    skip: true\n",
+			services: map[string]Service{
+				"web": {
+					Strategy:      "rolling",
+					HealthTimeout: 2 * time.Minute,
+					Prune:         &yes,
+					Hooks:         map[string][]Hook{"pre-restart": {{Exec: "pg_dump app", Service: "db"}}},
+				},
+				"blog/worker": {Skip: true},
+			},
+		},
+		{
+			name: "registries",
+			data: "registries:\n  ghcr.io:\n    username: bot\n    password-file: /run/secrets/ghcr\n",
+			registries: map[string]Registry{
+				"ghcr.io": {Username: "bot", PasswordFile: "/run/secrets/ghcr"},
+			},
+		},
+		{name: "not a mapping", data: "- strategy\n", wantErr: true},
+		{name: "invalid yaml", data: "strategy: [rolling\n", wantErr: true},
+		{name: "list of mappings", data: "profiles:\n  - name: web\n", wantErr: true},
+		{name: "services not a mapping", data: "services: [web]\n", wantErr: true},
+		{name: "unknown service field", data: "services:\n  web:\n    stratgy: rolling\n", wantErr: true},
+		{name: "invalid service value", data: "services:\n  web:\n    health_timeout: soon\n", wantErr: true},
+		{name: "unknown registry field", data: "registries:\n  ghcr.io:\n    user: bot\n", wantErr: true},
+		{name: "password and password file", data: "registries:\n  ghcr.io:\n    password: secret\n    password_file: /run/secrets/ghcr\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Parse([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.settings == nil {
+				tt.settings = map[string][]string{}
+			}
+			if tt.services == nil {
+				tt.services = map[string]Service{}
+			}
+			if tt.registries == nil {
+				tt.registries = map[string]Registry{}
+			}
+			if !reflect.DeepEqual(file.Settings, tt.settings) {
+				t.Errorf("Settings = %v, want %v", file.Settings, tt.settings)
+			}
+			if !reflect.DeepEqual(file.Services, tt.services) {
+				t.Errorf("Services = %+v, want %+v", file.Services, tt.services)
+			}
+			if !reflect.DeepEqual(file.Registries, tt.registries) {
+				t.Errorf("Registries = %+v, want %+v", file.Registries, tt.registries)
+			}
+		})
+	}
+}
+
+func TestServiceMerge(t *testing.T) {
+	yes, no := true, false
+	base := Service{
+		Strategy:      "recreate",
+		Prune:         &yes,
+		HealthTimeout: time.Minute,
+		Hooks: map[string][]Hook{
+			"pre-pull":    {{Command: "echo pull"}},
+			"pre-restart": {{Command: "echo restart"}},
+		},
+	}
+	override := Service{
+		Skip:  true,
+		Prune: &no,
+		Hooks: map[string][]Hook{"pre-restart": {{Exec: "pg_dump app"}}},
+	}
+	want := Service{
+		Skip:          true,
+		Strategy:      "recreate",
+		Prune:         &no,
+		HealthTimeout: time.Minute,
+		Hooks: map[string][]Hook{
+			"pre-pull":    {{Command: "echo pull"}},
+			"pre-restart": {{Exec: "pg_dump app"}},
+		},
+	}
+	if got := base.Merge(override); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+	if len(base.Hooks["pre-restart"]) != 1 || base.Hooks["pre-restart"][0].Command != "echo restart" {
+		t.Errorf("Merge changed the hooks of the service merged into: %+v", base.Hooks)
+	}
+	if got := base.Merge(Service{}); !reflect.DeepEqual(got, base) {
+		t.Errorf("Merge with an empty override = %+v, want %+v", got, base)
+	}
+}
+
+func TestProjectServices(t *testing.T) {
+	services := map[string]Service{
+		"web":        {Strategy: "rolling", HealthTimeout: time.Minute},
+		"db":         {Skip: true},
+		"blog/web":   {HealthTimeout: 5 * time.Minute},
+		"blog/cache": {Strategy: "recreate"},
+		"shop/db":    {Skip: false, Strategy: "recreate"},
+	}
+	tests := []struct {
+		project string
+		want    map[string]Service
+	}{
+		{
+			project: "blog",
+			want: map[string]Service{
+				"web":   {Strategy: "rolling", HealthTimeout: 5 * time.Minute},
+				"db":    {Skip: true},
+				"cache": {Strategy: "recreate"},
+			},
+		},
+		{
+			project: "shop",
+			want: map[string]Service{
+				"web": {Strategy: "rolling", HealthTimeout: time.Minute},
+				"db":  {Skip: true, Strategy: "recreate"},
+			},
+		},
+		{
+			project: "wiki",
+			want: map[string]Service{
+				"web": {Strategy: "rolling", HealthTimeout: time.Minute},
+				"db":  {Skip: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.project, func(t *testing.T) {
+			if got := ProjectServices(services, tt.project); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProjectServices(%s) = %+v, want %+v", tt.project, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"dc-update/internal/report"
+)
+
+// Defaults for volume backups
+const (
+	DefaultBackupKeep  = 3
+	DefaultBackupImage = "alpine:3"
+)
+
+// backupMetadataFile describes a backup, next to its volume archives
+const backupMetadataFile = "backup.json"
+
+// backupIDFormat names backups after the time they were taken, to the millisecond
+const backupIDFormat = "20060102-150405.000"
+
+// Backup is an archive of a service's volumes, taken before the service was updated. Each
+// volume is stored as <volume>.tar.gz in the backup's directory.
+type Backup struct {
+	ID        string    `json:"-"`
+	Path      string    `json:"-"`
+	Project   string    `json:"project"`
+	Service   string    `json:"service"`
+	Image     string    `json:"image"`
+	ImageID   string    `json:"image_id"` // image the service ran when the backup was taken
+	Volumes   []string  `json:"volumes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupFor reports whether the volumes of a service are backed up before it is updated
+func (opts *UpdaterOptions) backupFor(serviceName string) bool {
+	if backup := opts.service(serviceName).Backup; backup != nil {
+		return *backup
+	}
+	return opts.Backup
+}
+
+// serviceBackupDir returns the directory holding the backups of a service
+func (opts *UpdaterOptions) serviceBackupDir(serviceName string) string {
+	return filepath.Join(opts.BackupDir, opts.Compose.ProjectName(), serviceName)
+}
+
+// backupImage returns the image of the helper containers that archive volumes
+func (opts *UpdaterOptions) backupImage() string {
+	if opts.BackupImage != "" {
+		return opts.BackupImage
+	}
+	return DefaultBackupImage
+}
+
+// backupService archives the volumes of a service before it is recreated. The service is
+// stopped first so the archives are consistent, and started again if the backup fails.
+// Rolling updates replace running replicas, so their service is started again after the
+// backup as well.
+func (opts *UpdaterOptions) backupService(serviceName string, state *ServiceState, sw *SpinnerWrapper) error {
+	volumes, err := opts.serviceVolumes(state)
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	sw.UpdateSuffix(fmt.Sprintf("Stopping %s to back up its volumes", serviceName))
+	for _, containerID := range state.ContainerIDs {
+		if err := opts.stopContainer(serviceName, containerID); err != nil {
+			opts.startContainers(serviceName, state.ContainerIDs)
+			return err
+		}
+	}
+
+	backup, err := opts.createBackup(serviceName, state, volumes, sw)
+	if err != nil {
+		opts.startContainers(serviceName, state.ContainerIDs)
+		return fmt.Errorf("failed to back up volumes of %s: %w", serviceName, err)
+	}
+	state.Backup = backup.ID
+
+	if opts.useRollingUpdate(serviceName, state) {
+		sw.UpdateSuffix(fmt.Sprintf("Starting %s again after backing it up", serviceName))
+		for _, containerID := range state.ContainerIDs {
+			if err := opts.startContainer(serviceName, containerID); err != nil {
+				return fmt.Errorf("backed up %s but failed to start it again: %w", serviceName, err)
+			}
+		}
+	}
+
+	if err := opts.pruneBackups(serviceName); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove old backups of %s: %v\n", serviceName, err)
+	}
+	return nil
+}
+
+// serviceVolumes returns the volumes mounted by any replica of a service, without duplicates
+func (opts *UpdaterOptions) serviceVolumes(state *ServiceState) ([]string, error) {
+	seen := make(map[string]bool)
+	var volumes []string
+	for _, containerID := range state.ContainerIDs {
+		containerVolumes, err := opts.DockerClient.ContainerVolumes(containerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, volume := range containerVolumes {
+			if !seen[volume] {
+				seen[volume] = true
+				volumes = append(volumes, volume)
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// startContainers starts stopped containers of a service again after a failed backup
+func (opts *UpdaterOptions) startContainers(serviceName string, containerIDs []string) {
+	for _, containerID := range containerIDs {
+		if err := opts.startContainer(serviceName, containerID); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+// createBackup archives the given volumes into a new backup directory. A backup that
+// fails part way is removed.
+func (opts *UpdaterOptions) createBackup(serviceName string, state *ServiceState, volumes []string, sw *SpinnerWrapper) (*Backup, error) {
+	now := time.Now()
+	backup := &Backup{
+		Project:   opts.Compose.ProjectName(),
+		Service:   serviceName,
+		Image:     state.ImageName,
+		ImageID:   state.CurrentImageID,
+		Volumes:   volumes,
+		CreatedAt: now,
+	}
+	var err error
+	backup.ID, backup.Path, err = newBackupDir(opts.serviceBackupDir(serviceName), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	if err := opts.writeBackup(backup, sw); err != nil {
+		os.RemoveAll(backup.Path)
+		return nil, err
+	}
+	return backup, nil
+}
+
+// newBackupDir creates the directory of a backup taken at the given time and returns its ID
+// and path. Backups taken within the same millisecond get a numbered suffix.
+func newBackupDir(serviceDir string, at time.Time) (string, string, error) {
+	if err := os.MkdirAll(serviceDir, 0o700); err != nil {
+		return "", "", err
+	}
+	base := at.Format(backupIDFormat)
+	for n := 1; ; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		path := filepath.Join(serviceDir, id)
+		err := os.Mkdir(path, 0o700)
+		if err == nil {
+			return id, path, nil
+		}
+		if !os.IsExist(err) {
+			return "", "", err
+		}
+	}
+}
+
+// writeBackup writes the volume archives and metadata of a backup
+func (opts *UpdaterOptions) writeBackup(backup *Backup, sw *SpinnerWrapper) error {
+	for _, volume := range backup.Volumes {
+		sw.UpdateSuffix(fmt.Sprintf("Backing up volume %s of %s", volume, backup.Service))
+		if err := opts.exportVolume(volume, filepath.Join(backup.Path, volume+".tar.gz")); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(backup.Path, backupMetadataFile), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	return nil
+}
+
+// exportVolume writes the archive of a volume to path, only creating the file once the
+// archive is complete
+func (opts *UpdaterOptions) exportVolume(volume string, path string) error {
+	partial := path + ".partial"
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive of volume %s: %w", volume, err)
+	}
+
+	err = opts.DockerClient.ExportVolume(volume, opts.backupImage(), file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// Backups returns the backups of a service, newest first
+func (opts *UpdaterOptions) Backups(serviceName string) ([]*Backup, error) {
+	dir := opts.serviceBackupDir(serviceName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups of %s: %w", serviceName, err)
+	}
+
+	var backups []*Backup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		backup, err := readBackup(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// Not a complete backup, e.g. one interrupted by a crash
+			continue
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// readBackup reads the metadata of the backup in dir
+func readBackup(dir string) (*Backup, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupMetadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var backup Backup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("invalid backup metadata in %s: %w", dir, err)
+	}
+	backup.ID = filepath.Base(dir)
+	backup.Path = dir
+	return &backup, nil
+}
+
+// pruneBackups removes the backups of a service beyond the newest BackupKeep and those
+// older than BackupMaxAge. The newest backup is always kept.
+func (opts *UpdaterOptions) pruneBackups(serviceName string) error {
+	backups, err := opts.Backups(serviceName)
+	if err != nil {
+		return err
+	}
+
+	keep := opts.BackupKeep
+	if keep <= 0 {
+		keep = DefaultBackupKeep
+	}
+
+	var errs []error
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+		expired := opts.BackupMaxAge > 0 && time.Since(backup.CreatedAt) > opts.BackupMaxAge
+		if i < keep && !expired {
+			continue
+		}
+		if err := os.RemoveAll(backup.Path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RestoreBackup replaces the contents of a service's volumes with a backup. Running
+// containers of the service are stopped during the restore and started again afterwards,
+// on the image the backup was taken with, as a newer one may have migrated the data to a
+// format the backup doesn't have. The service's image reference is pointed at that image
+// again for it. With keepImage, the containers are started on their current image
+// instead. If a volume can't be restored, the containers are left stopped, as their
+// volumes may hold part of the backup.
+func (opts *UpdaterOptions) RestoreBackup(serviceName string, backupID string, keepImage bool) (*Restore, error) {
+	backup, err := readBackup(filepath.Join(opts.serviceBackupDir(serviceName), backupID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no backup %s of %s in %s", backupID, serviceName, opts.serviceBackupDir(serviceName))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	containerIDs, err := opts.Compose.GetCurrentContainerIds(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container IDs for %s: %w", serviceName, err)
+	}
+	restore := &Restore{Backup: backup}
+	if !keepImage {
+		if restore.RolledBack, restore.ReplacedImageID, err = opts.rollbackImage(serviceName, backup, containerIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	sw := opts.NewSpinnerWrapper(fmt.Sprintf("Restoring %s from backup %s", serviceName, backup.ID))
+	sw.Start()
+
+	for _, containerID := range containerIDs {
+		if err := opts.stopContainer(serviceName, containerID); err != nil {
+			sw.Stop(fmt.Sprintf("❌ Failed to stop %s", serviceName))
+			opts.startContainers(serviceName, containerIDs)
+			return nil, err
+		}
+	}
+
+	for _, volume := range backup.Volumes {
+		sw.UpdateSuffix(fmt.Sprintf("Restoring volume %s of %s", volume, serviceName))
+		if err := opts.importVolume(volume, filepath.Join(backup.Path, volume+".tar.gz")); err != nil {
+			sw.Stop(fmt.Sprintf("❌ Failed to restore volume %s of %s, it is left stopped", volume, serviceName))
+			return nil, fmt.Errorf("%w; %s is left stopped, start it once its volumes are fixed", err, serviceName)
+		}
+	}
+
+	if restore.RolledBack {
+		sw.UpdateSuffix(fmt.Sprintf("Starting %s on %s (%s)", serviceName, backup.Image, report.ShortID(backup.ImageID)))
+		if err := opts.DockerClient.TagImage(backup.ImageID, backup.Image); err != nil {
+			sw.Stop(fmt.Sprintf("❌ Restored the volumes of %s but failed to roll back its image, it is left stopped", serviceName))
+			return nil, fmt.Errorf("%w; %s is left stopped, start it on %s once it is tagged", err, serviceName, report.ShortID(backup.ImageID))
+		}
+		if err := opts.RestartContainer(serviceName); err != nil {
+			sw.Stop(fmt.Sprintf("❌ Restored the volumes of %s but failed to start it", serviceName))
+			return nil, err
+		}
+	} else {
+		for _, containerID := range containerIDs {
+			if err := opts.startContainer(serviceName, containerID); err != nil {
+				sw.Stop(fmt.Sprintf("❌ Restored the volumes of %s but failed to start it", serviceName))
+				return nil, err
+			}
+		}
+	}
+
+	sw.Stop(fmt.Sprintf("✅ Restored %d volume(s) of %s from backup %s", len(backup.Volumes), serviceName, backup.ID))
+	return restore, nil
+}
+
+// Restore is the outcome of restoring a backup
+type Restore struct {
+	Backup *Backup
+	// RolledBack reports whether the service was started on the image of the backup again
+	RolledBack bool
+	// ReplacedImageID is the image the service ran before it was rolled back, if any
+	ReplacedImageID string
+}
+
+// rollbackImage reports whether a service has to be started on the image of a backup
+// again, and which image it runs instead. Without running containers, the image its
+// reference names counts. The image of the backup must still be there to roll back to it.
+func (opts *UpdaterOptions) rollbackImage(serviceName string, backup *Backup, containerIDs []string) (bool, string, error) {
+	if backup.ImageID == "" {
+		return false, "", nil
+	}
+	imageIDs := make([]string, 0, len(containerIDs))
+	for _, containerID := range containerIDs {
+		imageID, err := opts.DockerClient.GetCurrentImageId(containerID)
+		if err != nil {
+			return false, "", err
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+	if len(containerIDs) == 0 {
+		imageID, err := opts.DockerClient.GetImageId(backup.Image)
+		if err != nil {
+			return false, "", err
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+	rollback, current := false, ""
+	for _, imageID := range imageIDs {
+		if imageID != backup.ImageID {
+			rollback, current = true, imageID
+		}
+	}
+	if !rollback {
+		return false, "", nil
+	}
+
+	if _, err := opts.DockerClient.GetImageInfo(backup.ImageID); err != nil {
+		return false, "", fmt.Errorf("%s ran %s (%s) when backup %s was taken, which is gone: %w; restore with --keep-image to start %s on its current image instead",
+			serviceName, backup.Image, report.ShortID(backup.ImageID), backup.ID, err, serviceName)
+	}
+	return true, current, nil
+}
+
+// importVolume restores a volume from the archive at path
+func (opts *UpdaterOptions) importVolume(volume string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive of volume %s: %w", volume, err)
+	}
+	defer file.Close()
+	return opts.DockerClient.ImportVolume(volume, opts.backupImage(), file)
+}
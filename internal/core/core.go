@@ -1,13 +1,19 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	"dc-update/internal/compose"
+	"dc-update/internal/config"
 	"dc-update/internal/docker"
+	"dc-update/internal/report"
+	"dc-update/internal/retry"
+	"dc-update/internal/schedule"
+	"dc-update/internal/state"
 
 	"github.com/briandowns/spinner"
 	"golang.org/x/term"
@@ -15,10 +21,38 @@ import (
 
 // UpdaterOptions holds configuration for the updater
 type UpdaterOptions struct {
-	ShowWarnings   bool
-	UseSpinners    bool
-	ComposeOpts    *compose.Options
-	DockerClient   *docker.Client
+	ShowWarnings     bool
+	UseSpinners      bool
+	Compose          compose.Backend
+	DockerClient     *docker.Client
+	Report           *report.Report
+	Prune            bool                      // remove superseded images after successful updates
+	PruneKeep        int                       // number of superseded images to keep per repository
+	Strategy         string                    // StrategyRecreate or StrategyRolling
+	RollingBatchSize int                       // replicas replaced at once with StrategyRolling
+	RollingPause     time.Duration             // pause between rolling batches
+	HealthTimeout    time.Duration             // how long to wait for new replicas to become healthy
+	Label            string                    // prefix for output lines, the project name in multi-project mode
+	Concurrency      int                       // number of services updated at once
+	Services         map[string]config.Service // per-service overrides from the configuration files
+	Backup           bool                      // archive the volumes of services before updating them
+	BackupDir        string                    // directory holding volume backups, one subdirectory per project
+	BackupKeep       int                       // number of backups kept per service
+	BackupMaxAge     time.Duration             // age after which backups are removed, 0 keeps them
+	BackupImage      string                    // image of the helper containers that archive volumes
+	Windows          schedule.Windows          // maintenance windows, updates outside them are deferred
+	MinAge           time.Duration             // how old new images must be before they are installed
+	PullOnly         bool                      // pull new images without installing them
+	State            *state.State              // freezes set with `dc-update freeze`
+	Stop             context.Context           // done once no further services should be updated, e.g. after Ctrl-C
+	PullTimeout      time.Duration             // how long pulling an image may take, 0 means no limit
+	StopTimeout      time.Duration             // how long stopping a service may take, 0 means no limit
+	StartTimeout     time.Duration             // how long starting a service may take, 0 means no limit
+	PullRetries      int                       // how often failed pulls are retried
+	HubRateLimit     string                    // HubRateLimitWarn, HubRateLimitDefer or HubRateLimitOff
+
+	pruneMu   sync.Mutex
+	hubBudget *hubBudget // shared with the other projects of the run
 }
 
 // isInteractiveTerminal checks if we're running in an interactive terminal
@@ -48,14 +82,25 @@ func isInteractiveTerminal() bool {
 	return true
 }
 
-// NewUpdaterOptions creates new updater options
-func NewUpdaterOptions(composeFile string, showWarnings bool, nonInteractive bool) (*UpdaterOptions, error) {
-	composeOpts := compose.NewOptions(composeFile)
-	
-	dockerClient, err := docker.NewClient()
+// NewUpdaterOptions creates new updater options. Without a compose file in composeConfig
+// no project is opened and Compose is nil, use ForProject to open projects. Cancelling
+// ctx aborts all Docker and compose operations.
+func NewUpdaterOptions(ctx context.Context, dockerConfig docker.Config, composeConfig compose.Config, showWarnings bool, nonInteractive bool) (*UpdaterOptions, error) {
+	dockerClient, err := docker.NewClient(ctx, dockerConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
+
+	var composeBackend compose.Backend
+	projectName := ""
+	if composeConfig.ComposeFile != "" {
+		composeBackend, err = compose.NewBackend(composeConfig, dockerClient)
+		if err != nil {
+			dockerClient.Close()
+			return nil, err
+		}
+		projectName = composeBackend.ProjectName()
+	}
 	
 	// Determine spinner usage: disable if explicitly non-interactive or if terminal is non-interactive
 	useSpinners := !nonInteractive && isInteractiveTerminal()
@@ -63,8 +108,17 @@ func NewUpdaterOptions(composeFile string, showWarnings bool, nonInteractive boo
 	return &UpdaterOptions{
 		ShowWarnings: showWarnings,
 		UseSpinners:  useSpinners,
-		ComposeOpts:  composeOpts,
+		Compose:      composeBackend,
 		DockerClient: dockerClient,
+		Report:       report.New(projectName),
+		Strategy:     StrategyRecreate,
+		Concurrency:  DefaultConcurrency,
+		PullTimeout:  DefaultPullTimeout,
+		StopTimeout:  DefaultStopTimeout,
+		StartTimeout: DefaultStartTimeout,
+		PullRetries:  retry.DefaultAttempts - 1,
+		HubRateLimit: HubRateLimitWarn,
+		hubBudget:    &hubBudget{},
 	}, nil
 }
 
@@ -78,6 +132,7 @@ type SpinnerWrapper struct {
 	spinner    *spinner.Spinner
 	useSpinner bool
 	prefix     string
+	label      string
 }
 
 // NewSpinnerWrapper creates a new spinner wrapper
@@ -85,7 +140,9 @@ func (opts *UpdaterOptions) NewSpinnerWrapper(message string) *SpinnerWrapper {
 	sw := &SpinnerWrapper{
 		useSpinner: opts.UseSpinners,
 		prefix:     message,
+		label:      opts.Label,
 	}
+	message = sw.labelled(message)
 	
 	if sw.useSpinner {
 		sw.spinner = spinner.New(spinner.CharSets[14], 100*time.Millisecond)
@@ -105,8 +162,17 @@ func (sw *SpinnerWrapper) Start() {
 	}
 }
 
+// labelled prefixes a message with the label, if any
+func (sw *SpinnerWrapper) labelled(message string) string {
+	if sw.label == "" || message == "" {
+		return message
+	}
+	return fmt.Sprintf("[%s] %s", sw.label, message)
+}
+
 // UpdateSuffix updates the spinner message
 func (sw *SpinnerWrapper) UpdateSuffix(message string) {
+	message = sw.labelled(message)
 	if sw.useSpinner && sw.spinner != nil {
 		sw.spinner.Suffix = fmt.Sprintf(" %s", message)
 	} else {
@@ -116,6 +182,7 @@ func (sw *SpinnerWrapper) UpdateSuffix(message string) {
 
 // Stop stops the spinner and shows final message
 func (sw *SpinnerWrapper) Stop(finalMessage string) {
+	finalMessage = sw.labelled(finalMessage)
 	if sw.useSpinner && sw.spinner != nil {
 		sw.spinner.FinalMSG = fmt.Sprintf("%s\n", finalMessage)
 		sw.spinner.Stop()
@@ -133,132 +200,287 @@ func (opts *UpdaterOptions) warnIfEnabled(sw *SpinnerWrapper, message string) {
 	}
 }
 
+// ServiceState describes the running and available images of a service
+type ServiceState struct {
+	ContainerIDs   []string
+	Replicas       []docker.ContainerStatus
+	ImageName      string
+	CurrentImageID string
+	LatestImageID  string
+	CurrentImage   *docker.ImageInfo
+	LatestImage    *docker.ImageInfo
+	PrunedImages   []string
+	Hooks          []report.HookResult
+	Backup         string // ID of the volume backup taken before the update
+	HoldReason     string // why an available update is not installed now
+	PullAttempts   int    // how often the image was pulled, more than once after retries
+}
+
+// IsRunning reports whether the service has at least one container
+func (s *ServiceState) IsRunning() bool {
+	return len(s.ContainerIDs) > 0
+}
+
+// NeedsUpdate reports whether any replica runs an image other than the pulled one
+func (s *ServiceState) NeedsUpdate() bool {
+	if s.LatestImageID == "" {
+		return false
+	}
+	for _, replica := range s.Replicas {
+		if replica.ImageID != s.LatestImageID {
+			return true
+		}
+	}
+	return false
+}
+
+// newResult builds the report entry for a service from its state
+func newResult(serviceName string, status report.Status, state *ServiceState, err error) report.ServiceResult {
+	result := report.ServiceResult{
+		Service: serviceName,
+		Status:  status,
+		Err:     err,
+	}
+	if state != nil {
+		result.Image = state.ImageName
+		result.OldImageID = state.CurrentImageID
+		result.NewImageID = state.LatestImageID
+		result.OldImage = state.CurrentImage
+		result.NewImage = state.LatestImage
+		result.PrunedImages = state.PrunedImages
+		result.Replicas = state.Replicas
+		result.Hooks = state.Hooks
+		result.Backup = state.Backup
+		result.Reason = state.HoldReason
+		result.PullAttempts = state.PullAttempts
+	}
+	return result
+}
+
+// describe names a service along with the version change between its images
+func describe(serviceName string, state *ServiceState) string {
+	return newResult(serviceName, "", state, nil).Description()
+}
+
+// replicaSuffix summarizes replica status for services with more than one container
+func replicaSuffix(state *ServiceState) string {
+	result := newResult("", "", state, nil)
+	if len(result.Replicas) <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", result.ReplicaSummary())
+}
+
+// recordResult adds the outcome for a service to the run report
+func (opts *UpdaterOptions) recordResult(serviceName string, status report.Status, state *ServiceState, err error) {
+	opts.Report.Add(newResult(serviceName, status, state, err))
+}
+
+// stopContainer stops a single container of a service within the service's stop timeout
+func (opts *UpdaterOptions) stopContainer(serviceName string, containerID string) error {
+	timeout := opts.stopTimeoutFor(serviceName)
+	client, cancel := opts.DockerClient.WithTimeout(timeout)
+	defer cancel()
+
+	if err := client.StopContainer(containerID); err != nil {
+		if client.TimedOut() {
+			return fmt.Errorf("failed to stop container %s: %w", containerID, client.TimeoutErr(err))
+		}
+		return err
+	}
+	return nil
+}
+
+// startContainer starts a single container of a service within the service's start timeout
+func (opts *UpdaterOptions) startContainer(serviceName string, containerID string) error {
+	timeout := opts.startTimeoutFor(serviceName)
+	client, cancel := opts.DockerClient.WithTimeout(timeout)
+	defer cancel()
+
+	if err := client.StartContainer(containerID); err != nil {
+		if client.TimedOut() {
+			return fmt.Errorf("failed to start container %s: %w", containerID, client.TimeoutErr(err))
+		}
+		return err
+	}
+	return nil
+}
+
 // RestartContainer stops, removes, and starts a container
 func (opts *UpdaterOptions) RestartContainer(serviceName string) error {
-	if err := opts.ComposeOpts.StopContainer(serviceName); err != nil {
+	if err := opts.Compose.StopContainer(serviceName, opts.stopTimeoutFor(serviceName)); err != nil {
 		return fmt.Errorf("failed to stop container %s: %w", serviceName, err)
 	}
 	
-	if err := opts.ComposeOpts.RemoveContainer(serviceName); err != nil {
+	if err := opts.Compose.RemoveContainer(serviceName, opts.stopTimeoutFor(serviceName)); err != nil {
 		return fmt.Errorf("failed to remove container %s: %w", serviceName, err)
 	}
 	
-	if err := opts.ComposeOpts.StartContainer(serviceName); err != nil {
+	if err := opts.Compose.StartContainer(serviceName, opts.startTimeoutFor(serviceName)); err != nil {
 		return fmt.Errorf("failed to start container %s: %w", serviceName, err)
 	}
 	
 	return nil
 }
 
-// UpdateContainer checks if a container needs updating and updates if necessary
-func (opts *UpdaterOptions) UpdateContainer(serviceName string) error {
-	sw := opts.NewSpinnerWrapper(fmt.Sprintf("Updating %s", serviceName))
-	sw.Start()
-	
+// ResetReport starts a new report, e.g. between runs of a long-lived process. The Docker
+// Hub pull budget is checked again in the next run.
+func (opts *UpdaterOptions) ResetReport() {
+	opts.Report = report.New(opts.Compose.ProjectName())
+	opts.hubBudget = &hubBudget{}
+}
+
+// resolveService looks up the containers of a service and the images they run. On failure
+// the spinner is stopped with an error message.
+func (opts *UpdaterOptions) resolveService(serviceName string, sw *SpinnerWrapper) (*ServiceState, error) {
+	state := &ServiceState{}
+
 	// First validate that the service exists in the compose file
-	if err := opts.ComposeOpts.ValidateServiceExists(serviceName); err != nil {
-		sw.Stop(fmt.Sprintf("❌ Service '%s' does not exist in docker-compose file", serviceName))
-		return err
+	if err := opts.Compose.ValidateServiceExists(serviceName); err != nil {
+		sw.Stop(fmt.Sprintf("❌ Cannot update %s: %v", serviceName, err))
+		return state, err
 	}
 	
-	// Get current container ID
-	currentContainerID, err := opts.ComposeOpts.GetCurrentContainerId(serviceName)
+	// Get the IDs of all the service's containers, one per replica
+	containerIDs, err := opts.Compose.GetCurrentContainerIds(serviceName)
 	if err != nil {
-		sw.Stop(fmt.Sprintf("❌ Failed to get container ID for %s", serviceName))
-		return fmt.Errorf("failed to get container ID for %s: %w", serviceName, err)
+		sw.Stop(fmt.Sprintf("❌ Failed to get container IDs for %s", serviceName))
+		return state, fmt.Errorf("failed to get container IDs for %s: %w", serviceName, err)
 	}
 	
-	if currentContainerID == "" {
-		opts.warnIfEnabled(sw, fmt.Sprintf("%s is not running", serviceName))
-		return nil
+	if len(containerIDs) == 0 {
+		return state, nil
 	}
+	state.ContainerIDs = containerIDs
 	
 	// Get the expected image name from the docker-compose file
-	expectedImageName, err := opts.ComposeOpts.GetServiceImageName(serviceName)
+	expectedImageName, err := opts.Compose.GetServiceImageName(serviceName)
 	if err != nil {
 		sw.Stop(fmt.Sprintf("❌ Failed to get image name for %s", serviceName))
-		return fmt.Errorf("failed to get image name for %s: %w", serviceName, err)
-	}
-	
-	// Get current container's image ID
-	currentImageID, err := opts.DockerClient.GetCurrentImageId(currentContainerID)
-	if err != nil {
-		sw.Stop(fmt.Sprintf("❌ Failed to get current image ID for %s", serviceName))
-		return fmt.Errorf("failed to get current image ID for %s: %w", serviceName, err)
-	}
-	
-	// Pull the expected image to ensure we have the latest version
-	if err := opts.ComposeOpts.PullContainer(serviceName); err != nil {
-		sw.Stop(fmt.Sprintf("❌ Failed to pull image for %s", serviceName))
-		return fmt.Errorf("failed to pull image for %s: %w", serviceName, err)
+		return state, fmt.Errorf("failed to get image name for %s: %w", serviceName, err)
 	}
+	state.ImageName = expectedImageName
 	
-	// Refresh image cache after pull to ensure we see the latest images
-	if err := opts.DockerClient.RefreshImageCache(); err != nil {
-		sw.Stop(fmt.Sprintf("❌ Failed to refresh image cache for %s", serviceName))
-		return fmt.Errorf("failed to refresh image cache for %s: %w", serviceName, err)
+	// Inspect every replica to get the images they currently run
+	if err := opts.refreshReplicas(state); err != nil {
+		sw.Stop(fmt.Sprintf("❌ Failed to inspect containers of %s", serviceName))
+		return state, fmt.Errorf("failed to inspect containers of %s: %w", serviceName, err)
 	}
-	
+	state.CurrentImageID = state.Replicas[0].ImageID
+	return state, nil
+}
+
+// compareImages looks up the image the service's image reference points to after a pull,
+// along with the metadata of the old and new images
+func (opts *UpdaterOptions) compareImages(serviceName string, state *ServiceState, images *imageLookup) error {
 	// Get the expected image ID after pulling
-	expectedImageID, err := opts.DockerClient.GetImageId(expectedImageName)
+	expectedImageID, err := images.id(state.ImageName)
 	if err != nil {
-		sw.Stop(fmt.Sprintf("❌ Failed to get expected image ID for %s", serviceName))
 		return fmt.Errorf("failed to get expected image ID for %s: %w", serviceName, err)
 	}
-	
-	// Compare image IDs and update if different
-	if expectedImageID != "" && currentImageID != expectedImageID {
-		sw.UpdateSuffix(fmt.Sprintf("Updating and restarting %s", serviceName))
-		
-		if err := opts.RestartContainer(serviceName); err != nil {
-			sw.Stop(fmt.Sprintf("❌ Failed to restart %s", serviceName))
+	state.LatestImageID = expectedImageID
+
+	// Report the outdated image if replicas run different images
+	for _, replica := range state.Replicas {
+		if replica.ImageID != expectedImageID {
+			state.CurrentImageID = replica.ImageID
+			break
+		}
+	}
+
+	state.CurrentImage = images.info(state.CurrentImageID)
+	if expectedImageID != "" {
+		state.LatestImage = images.info(expectedImageID)
+	}
+
+	return nil
+}
+
+// refreshReplicas inspects each of the service's containers
+func (opts *UpdaterOptions) refreshReplicas(state *ServiceState) error {
+	replicas := make([]docker.ContainerStatus, 0, len(state.ContainerIDs))
+	for _, containerID := range state.ContainerIDs {
+		status, err := opts.DockerClient.GetContainerStatus(containerID)
+		if err != nil {
 			return err
 		}
-		
-		sw.Stop(fmt.Sprintf("✅ Updated %s", serviceName))
-	} else {
-		sw.Stop(fmt.Sprintf("✅ %s is already up to date", serviceName))
+		replicas = append(replicas, *status)
 	}
-	
+	state.Replicas = replicas
 	return nil
 }
 
-// UpdateContainersConcurrently processes multiple containers with controlled concurrency
-func (opts *UpdaterOptions) UpdateContainersConcurrently(serviceNames []string) error {
-	const maxConcurrency = 3 // Limit concurrent operations to avoid overwhelming Docker daemon
-	
-	// Use a semaphore pattern to limit concurrency
-	semaphore := make(chan struct{}, maxConcurrency)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var errors []error
-	
-	for _, serviceName := range serviceNames {
-		wg.Add(1)
-		go func(name string) {
-			defer wg.Done()
-			
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-			
-			// Update the container
-			if err := opts.UpdateContainer(name); err != nil {
-				mu.Lock()
-				errors = append(errors, fmt.Errorf("error updating %s: %w", name, err))
-				fmt.Fprintf(os.Stderr, "Error updating %s: %v\n", name, err)
-				mu.Unlock()
-			}
-		}(serviceName)
+// reloadReplicas looks up the service's containers again after they were recreated
+func (opts *UpdaterOptions) reloadReplicas(serviceName string, state *ServiceState) error {
+	containerIDs, err := opts.Compose.GetCurrentContainerIds(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get container IDs for %s: %w", serviceName, err)
 	}
+	state.ContainerIDs = containerIDs
+	return opts.refreshReplicas(state)
+}
+
+// UpdateContainer updates a single service if a newer image is available
+func (opts *UpdaterOptions) UpdateContainer(serviceName string) error {
+	return opts.UpdateContainersConcurrently([]string{serviceName})
+}
+
+// installUpdate replaces the containers of a service whose newer image was pulled, unless
+// the update is held back, and returns the outcome for the report
+func (opts *UpdaterOptions) installUpdate(serviceName string, state *ServiceState, sw *SpinnerWrapper) (report.Status, error) {
+	status, reason := opts.hold(serviceName, state)
+	state.HoldReason = reason
+	switch status {
+	case report.StatusPending:
+		sw.Stop(fmt.Sprintf("⏳ %s is pending: %s", describe(serviceName, state), reason))
+		return status, nil
+	case report.StatusDeferred:
+		sw.Stop(fmt.Sprintf("⏸️  Deferred %s: %s", describe(serviceName, state), reason))
+		return status, nil
+	}
+
+	if err := opts.runHooks(serviceName, HookPreRestart, state, sw); err != nil {
+		sw.Stop(fmt.Sprintf("❌ %s hook of %s failed, not updating", HookPreRestart, serviceName))
+		return report.StatusFailed, err
+	}
+
+	if opts.backupFor(serviceName) {
+		if err := opts.backupService(serviceName, state, sw); err != nil {
+			sw.Stop(fmt.Sprintf("❌ Failed to back up %s, not updating", serviceName))
+			return report.StatusFailed, err
+		}
+	}
+
+	sw.UpdateSuffix(fmt.Sprintf("Updating and restarting %s", serviceName))
 	
-	// Wait for all goroutines to complete
-	wg.Wait()
-	
-	// Return the first error if any occurred
-	if len(errors) > 0 {
-		return errors[0]
+	restart := func() error { return opts.RestartContainer(serviceName) }
+	if opts.useRollingUpdate(serviceName, state) {
+		restart = func() error { return opts.RollingRestart(serviceName, state, sw) }
+	}
+	if err := restart(); err != nil {
+		sw.Stop(fmt.Sprintf("❌ Failed to restart %s", serviceName))
+		return report.StatusFailed, err
 	}
 	
-	return nil
-}
\ No newline at end of file
+	// Record the status of each new replica for the report
+	if err := opts.reloadReplicas(serviceName, state); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	if err := opts.runHooks(serviceName, HookPostRestart, state, sw); err != nil {
+		sw.Stop(fmt.Sprintf("❌ Updated %s but its %s hook failed", describe(serviceName, state), HookPostRestart))
+		return report.StatusFailed, err
+	}
+
+	if opts.pruneFor(serviceName) {
+		sw.UpdateSuffix(fmt.Sprintf("Pruning superseded images of %s", serviceName))
+		pruned, err := opts.pruneImages(serviceName, state)
+		state.PrunedImages = pruned
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to prune images of %s: %v\n", serviceName, err)
+		}
+	}
+
+	sw.Stop(fmt.Sprintf("✅ Updated %s%s", describe(serviceName, state), replicaSuffix(state)))
+	return report.StatusUpdated, nil
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"dc-update/internal/report"
+	"dc-update/internal/schedule"
+)
+
+// windowsFor returns the maintenance windows of a service. Windows set for the service
+// replace those of the project.
+func (opts *UpdaterOptions) windowsFor(serviceName string) schedule.Windows {
+	if specs := opts.service(serviceName).MaintenanceWindows; len(specs) > 0 {
+		// Already checked by ValidateServices
+		windows, _ := schedule.ParseWindows(specs)
+		return windows
+	}
+	return opts.Windows
+}
+
+// hold decides whether the available update of a service must not be installed now. It
+// returns StatusDeferred for freezes and closed maintenance windows, StatusPending while
+// the new image is younger than the minimum age, and "" if the update may be installed.
+// The reason explains the hold.
+func (opts *UpdaterOptions) hold(serviceName string, state *ServiceState) (report.Status, string) {
+	now := time.Now()
+
+	if opts.State != nil {
+		var repoDigests []string
+		if state.LatestImage != nil {
+			repoDigests = state.LatestImage.RepoDigests
+		}
+		for _, freeze := range opts.State.ActiveFreezes(opts.Compose.ProjectName(), serviceName, now) {
+			if freeze.Matches(state.LatestImageID, repoDigests) {
+				return report.StatusDeferred, freeze.String()
+			}
+		}
+	}
+
+	if minAge := opts.minAgeFor(serviceName); minAge > 0 {
+		if state.LatestImage == nil || state.LatestImage.CreatedAt.IsZero() {
+			fmt.Fprintf(os.Stderr, "Warning: the creation time of the new image of %s is unknown, ignoring the minimum age\n", opts.qualified(serviceName))
+		} else if ready := state.LatestImage.CreatedAt.Add(minAge); now.Before(ready) {
+			return report.StatusPending, fmt.Sprintf("cooling down until %s, min age %s", ready.Local().Format("Mon 2006-01-02 15:04 MST"), minAge)
+		}
+	}
+
+	if windows := opts.windowsFor(serviceName); !windows.Open(now) {
+		return report.StatusDeferred, fmt.Sprintf("outside maintenance window, next one opens %s", windows.Next(now).Format("Mon 2006-01-02 15:04 MST"))
+	}
+	return "", ""
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"dc-update/internal/config"
+	"dc-update/internal/interrupt"
+	"dc-update/internal/report"
+)
+
+// Hook events, in the order they happen during an update
+const (
+	HookPrePull     = "pre-pull"
+	HookPreRestart  = "pre-restart"
+	HookPostRestart = "post-restart"
+	HookOnFailure   = "on-failure"
+)
+
+// hookEvents are all valid hook events
+var hookEvents = []string{HookPrePull, HookPreRestart, HookPostRestart, HookOnFailure}
+
+// DefaultHookTimeout is how long a hook may run unless it sets its own timeout
+const DefaultHookTimeout = 5 * time.Minute
+
+// ExtensionKey is the compose service extension holding per-service settings
+const ExtensionKey = "x-dc-update"
+
+// LoadServiceExtensions reads the x-dc-update extension of every service in the compose
+// project. Settings from the configuration files take precedence over the extension.
+func (opts *UpdaterOptions) LoadServiceExtensions() error {
+	extensions, err := opts.Compose.ServiceExtensions(ExtensionKey)
+	if err != nil {
+		return fmt.Errorf("failed to read %s settings: %w", ExtensionKey, err)
+	}
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	services := make(map[string]config.Service, len(opts.Services)+len(extensions))
+	for name, service := range opts.Services {
+		services[name] = service
+	}
+	for name, value := range extensions {
+		extension, err := config.DecodeService(value)
+		if err != nil {
+			return fmt.Errorf("service %s: invalid %s: %w", name, ExtensionKey, err)
+		}
+		services[name] = extension.Merge(opts.Services[name])
+	}
+	opts.Services = services
+	return nil
+}
+
+// validateHooks checks the events and hooks of a service
+func validateHooks(serviceName string, hooks map[string][]config.Hook) error {
+	for event, eventHooks := range hooks {
+		if !slices.Contains(hookEvents, event) {
+			return fmt.Errorf("service %s: unknown hook event '%s' (expected one of %v)", serviceName, event, hookEvents)
+		}
+		for _, hook := range eventHooks {
+			if (hook.Command == "") == (hook.Exec == "") {
+				return fmt.Errorf("service %s: each %s hook needs either command or exec", serviceName, event)
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks runs the hooks of a service for an event in order, recording their results in
+// the service state. It stops at the first failing hook and returns its error.
+func (opts *UpdaterOptions) runHooks(serviceName string, event string, state *ServiceState, sw *SpinnerWrapper) error {
+	for _, hook := range opts.service(serviceName).Hooks[event] {
+		sw.UpdateSuffix(fmt.Sprintf("Running %s hook of %s: %s", event, serviceName, hook))
+
+		start := time.Now()
+		result := report.HookResult{Event: event, Hook: hook.String()}
+		output, exitCode, err := opts.runHook(serviceName, event, hook, state)
+		result.Output = report.TruncateOutput(output)
+		result.ExitCode = exitCode
+		result.Duration = time.Since(start)
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("exited with code %d", exitCode)
+		}
+		if err != nil {
+			result.Err = err
+			state.Hooks = append(state.Hooks, result)
+			return fmt.Errorf("%s hook '%s' of %s failed: %w", event, hook, serviceName, err)
+		}
+		state.Hooks = append(state.Hooks, result)
+	}
+	return nil
+}
+
+// hookEnv describes the update to a hook through environment variables
+func (opts *UpdaterOptions) hookEnv(serviceName string, event string, state *ServiceState) []string {
+	return []string{
+		"DC_UPDATE_PROJECT=" + opts.Compose.ProjectName(),
+		"DC_UPDATE_SERVICE=" + serviceName,
+		"DC_UPDATE_EVENT=" + event,
+		"DC_UPDATE_IMAGE=" + state.ImageName,
+		"DC_UPDATE_OLD_IMAGE_ID=" + state.CurrentImageID,
+		"DC_UPDATE_NEW_IMAGE_ID=" + state.LatestImageID,
+	}
+}
+
+// runFailureHooks runs the on-failure hooks of a service after its update failed
+func (opts *UpdaterOptions) runFailureHooks(serviceName string, state *ServiceState) {
+	if len(opts.service(serviceName).Hooks[HookOnFailure]) == 0 {
+		return
+	}
+
+	sw := opts.NewSpinnerWrapper(fmt.Sprintf("Running %s hooks of %s", HookOnFailure, serviceName))
+	sw.Start()
+	if err := opts.runHooks(serviceName, HookOnFailure, state, sw); err != nil {
+		sw.Stop(fmt.Sprintf("❌ %v", err))
+		return
+	}
+	sw.Stop(fmt.Sprintf("🪝 Ran %s hooks of %s", HookOnFailure, serviceName))
+}
+
+// runHook runs a single hook and returns its output and exit code
+func (opts *UpdaterOptions) runHook(serviceName string, event string, hook config.Hook, state *ServiceState) (string, int, error) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	env := opts.hookEnv(serviceName, event, state)
+
+	if hook.Exec != "" {
+		target := serviceName
+		if hook.Service != "" {
+			target = hook.Service
+		}
+		containerIDs, err := opts.Compose.GetCurrentContainerIds(target)
+		if err != nil {
+			return "", -1, fmt.Errorf("failed to find a container of %s: %w", target, err)
+		}
+		if len(containerIDs) == 0 {
+			return "", -1, fmt.Errorf("%s has no running container to exec in", target)
+		}
+
+		result, err := opts.DockerClient.Exec(containerIDs[0], []string{"sh", "-c", hook.Exec}, hook.User, env, timeout)
+		if result == nil {
+			return "", -1, err
+		}
+		return result.Output, result.ExitCode, err
+	}
+
+	// Host commands run in the project directory and reach the same daemon as dc-update
+	ctx, cancel := context.WithTimeout(opts.DockerClient.Context(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	interrupt.Detach(cmd)
+	cmd.Dir = opts.Compose.ProjectDir()
+	cmd.Env = append(append(os.Environ(), opts.DockerClient.Env()...), env...)
+	// Don't wait forever for background processes of the hook holding on to its output
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err := cmd.CombinedOutput()
+	if opts.aborted() {
+		return string(output), -1, errors.New("aborted")
+	}
+	if ctx.Err() != nil {
+		return string(output), -1, fmt.Errorf("timed out after %s", timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(output), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return string(output), -1, err
+	}
+	return string(output), 0, nil
+}
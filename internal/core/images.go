@@ -0,0 +1,84 @@
+package core
+
+import (
+	"dc-update/internal/docker"
+)
+
+// imageGroup is an image reference and the running services using it, pulled once for
+// all of them
+type imageGroup struct {
+	image    string // normalized image reference
+	services []int  // indexes of the services in the pull phase
+}
+
+// groupByImage groups services by their normalized image reference, keeping the order in
+// which each image first appears. Services without a state are skipped.
+func groupByImage(states []*ServiceState) []imageGroup {
+	var groups []imageGroup
+	index := make(map[string]int)
+	for i, state := range states {
+		if state == nil {
+			continue
+		}
+		image := docker.NormalizeImageRef(state.ImageName)
+		g, ok := index[image]
+		if !ok {
+			g = len(groups)
+			index[image] = g
+			groups = append(groups, imageGroup{image: image})
+		}
+		groups[g].services = append(groups[g].services, i)
+	}
+	return groups
+}
+
+// imageLookup remembers the image IDs and metadata looked up while comparing services,
+// so services sharing an image resolve and inspect it only once
+type imageLookup struct {
+	docker *docker.Client
+	ids    map[string]string            // image ID by normalized reference
+	infos  map[string]*docker.ImageInfo // metadata by image ID, nil if inspecting failed
+}
+
+// newImageLookup returns an empty lookup
+func newImageLookup(client *docker.Client) *imageLookup {
+	return &imageLookup{
+		docker: client,
+		ids:    make(map[string]string),
+		infos:  make(map[string]*docker.ImageInfo),
+	}
+}
+
+// id returns the ID of the local image an image reference points to
+func (l *imageLookup) id(imageName string) (string, error) {
+	key := docker.NormalizeImageRef(imageName)
+	if id, ok := l.ids[key]; ok {
+		return id, nil
+	}
+	id, err := l.docker.GetImageId(imageName)
+	if err != nil {
+		return "", err
+	}
+	l.ids[key] = id
+	return id, nil
+}
+
+// info returns the metadata of an image, or nil if it can't be inspected. Image labels
+// are informational only, so failing to read them is not an error.
+func (l *imageLookup) info(imageID string) *docker.ImageInfo {
+	if info, ok := l.infos[imageID]; ok {
+		return info
+	}
+	info, _ := l.docker.GetImageInfo(imageID)
+	l.infos[imageID] = info
+	return info
+}
+
+// pulledImages returns the image references of the groups
+func pulledImages(groups []imageGroup) []string {
+	images := make([]string, len(groups))
+	for i, group := range groups {
+		images[i] = group.image
+	}
+	return images
+}
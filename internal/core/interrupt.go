@@ -0,0 +1,52 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"dc-update/internal/report"
+)
+
+// errInterrupted is the error of projects that weren't started because the run was
+// interrupted
+var errInterrupted = errors.New("interrupted before the project was updated")
+
+// stopping reports whether the run was interrupted, so no further services should be
+// updated
+func (opts *UpdaterOptions) stopping() bool {
+	return opts.Stop != nil && opts.Stop.Err() != nil
+}
+
+// aborted reports whether running Docker and compose operations were cancelled
+func (opts *UpdaterOptions) aborted() bool {
+	return opts.DockerClient.Context().Err() != nil
+}
+
+// interrupted records a service the interrupted run left alone
+func (opts *UpdaterOptions) interrupted(serviceName string, state *ServiceState, reason string) {
+	result := newResult(serviceName, report.StatusInterrupted, state, nil)
+	result.Reason = reason
+	opts.Report.Add(result)
+	opts.println(fmt.Sprintf("⏹️  %s: %s", serviceName, reason))
+}
+
+// leftState describes the containers an aborted update left behind, e.g.
+// "left with app-web-1 exited"
+func (opts *UpdaterOptions) leftState(serviceName string) string {
+	// The client's own calls are cancelled by now
+	client := opts.DockerClient.Detached()
+	containers, err := client.ListServiceContainers(opts.Compose.ProjectName(), serviceName, true)
+	if err != nil {
+		return fmt.Sprintf("state unknown: %v", err)
+	}
+	if len(containers) == 0 {
+		return "left without containers"
+	}
+
+	states := make([]string, 0, len(containers))
+	for _, ctr := range containers {
+		states = append(states, fmt.Sprintf("%s %s", strings.TrimPrefix(ctr.Names[0], "/"), ctr.State))
+	}
+	return "left with " + strings.Join(states, ", ")
+}
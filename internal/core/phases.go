@@ -0,0 +1,349 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"dc-update/internal/compose"
+	"dc-update/internal/report"
+	"dc-update/internal/retry"
+)
+
+// pulledService is a running service whose newer image was pulled in the first phase of
+// an update
+type pulledService struct {
+	name  string
+	state *ServiceState
+}
+
+// updateRun collects the errors of the services failing during an update
+type updateRun struct {
+	mu        sync.Mutex
+	errs      []error
+	checkOnly bool // services are only checked, so failures don't run on-failure hooks
+}
+
+// fail records a failed service, runs its on-failure hooks and logs the error. Services
+// whose update was aborted record the state their containers were left in instead.
+func (opts *UpdaterOptions) fail(run *updateRun, serviceName string, state *ServiceState, err error) {
+	action := "checking"
+	if !run.checkOnly {
+		action = "updating"
+		if opts.aborted() {
+			err = fmt.Errorf("aborted, %s: %w", opts.leftState(serviceName), err)
+		} else {
+			opts.runFailureHooks(serviceName, state)
+		}
+	}
+	opts.recordResult(serviceName, report.StatusFailed, state, err)
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.errs = append(run.errs, fmt.Errorf("error %s %s: %w", action, serviceName, err))
+	fmt.Fprintf(os.Stderr, "Error %s %s: %v\n", action, opts.qualified(serviceName), err)
+}
+
+// UpdateContainersConcurrently updates services in two phases. First the images of all
+// services are pulled and compared with the running containers, then the outdated
+// services are restarted. A slow pull thus doesn't stretch the time in which some
+// services already run their new image and others don't. With PullOnly, the services
+// are left alone after their images were pulled.
+func (opts *UpdaterOptions) UpdateContainersConcurrently(serviceNames []string) error {
+	run := &updateRun{}
+
+	pulled := opts.pullPhase(run, serviceNames)
+	if opts.PullOnly {
+		for _, service := range pulled {
+			opts.stageUpdate(service)
+		}
+	} else {
+		opts.restartPhase(run, pulled)
+	}
+
+	return run.err()
+}
+
+// CheckContainers pulls the images of services and compares them with the running
+// containers, without restarting anything. Outdated services are reported as having an
+// update available.
+func (opts *UpdaterOptions) CheckContainers(serviceNames []string) error {
+	run := &updateRun{checkOnly: true}
+	for _, service := range opts.pullPhase(run, serviceNames) {
+		_, service.state.HoldReason = opts.hold(service.name, service.state)
+		opts.println(fmt.Sprintf("🆕 %s has an update available", describe(service.name, service.state)))
+		opts.recordResult(service.name, report.StatusAvailable, service.state, nil)
+	}
+	return run.err()
+}
+
+// err returns the first error of the run, if any
+func (run *updateRun) err() error {
+	if len(run.errs) > 0 {
+		return run.errs[0]
+	}
+	return nil
+}
+
+// forEach calls fn with the indexes 0 to n-1, at most Concurrency calls at a time, and
+// waits for all of them to return
+func (opts *UpdaterOptions) forEach(n int, fn func(i int)) {
+	// Limit concurrent operations to avoid overwhelming Docker daemon
+	maxConcurrency := opts.Concurrency
+	if maxConcurrency < 1 {
+		maxConcurrency = DefaultConcurrency
+	}
+
+	semaphore := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// pullPhase resolves the services, runs the pre-pull hooks of those about to be pulled
+// unless they are only checked, pulls the images of all running services at once and
+// compares them with the running containers. Services sharing an image pull and inspect
+// it only once. Services that fail, aren't running, are up to date or deferred for lack of
+// Docker Hub pulls are recorded right away, the outdated ones are returned. Once the run
+// is interrupted, the remaining services are left unchecked.
+func (opts *UpdaterOptions) pullPhase(run *updateRun, serviceNames []string) []pulledService {
+	states := make([]*ServiceState, len(serviceNames))
+	opts.forEach(len(serviceNames), func(i int) {
+		name := serviceNames[i]
+		if opts.stopping() {
+			opts.interrupted(name, &ServiceState{}, "not checked for updates")
+			return
+		}
+		sw := opts.NewSpinnerWrapper(fmt.Sprintf("Checking %s", name))
+		sw.Start()
+
+		state, err := opts.resolveService(name, sw)
+		switch {
+		case err != nil:
+			opts.fail(run, name, state, err)
+		case !state.IsRunning():
+			opts.warnIfEnabled(sw, fmt.Sprintf("%s is not running", name))
+			opts.recordResult(name, report.StatusNotRunning, state, nil)
+		default:
+			sw.Stop(fmt.Sprintf("🔍 Checked %s", name))
+			states[i] = state
+		}
+	})
+
+	groups := groupByImage(states)
+	if len(groups) == 0 {
+		return nil
+	}
+	if opts.stopping() {
+		for i, state := range states {
+			if state != nil {
+				opts.interrupted(serviceNames[i], state, "not checked for updates")
+			}
+		}
+		return nil
+	}
+
+	groups = opts.limitHubPulls(serviceNames, states, groups)
+	if !run.checkOnly {
+		groups = opts.prePullHooks(run, serviceNames, states)
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	pullErrs := opts.pullImages(serviceNames, states, groups)
+
+	// Refresh only the pulled images in the cache so the latest ones are seen
+	refreshErr := opts.DockerClient.RefreshImages(pulledImages(groups))
+
+	images := newImageLookup(opts.DockerClient)
+	var pulled []pulledService
+	for i, state := range states {
+		if state == nil {
+			continue
+		}
+		name := serviceNames[i]
+
+		if err := pullErrs[name]; err != nil {
+			opts.fail(run, name, state, err)
+			continue
+		}
+		if refreshErr != nil {
+			opts.fail(run, name, state, fmt.Errorf("failed to refresh image cache for %s: %w", name, refreshErr))
+			continue
+		}
+		if err := opts.compareImages(name, state, images); err != nil {
+			opts.fail(run, name, state, err)
+			continue
+		}
+
+		if !state.NeedsUpdate() {
+			opts.println(fmt.Sprintf("✅ %s is already up to date", name))
+			opts.recordResult(name, report.StatusUpToDate, state, nil)
+			continue
+		}
+		pulled = append(pulled, pulledService{name: name, state: state})
+	}
+	return pulled
+}
+
+// prePullHooks runs the pre-pull hooks of the services whose images are about to be
+// pulled. Services whose hooks fail are recorded as failed and left out of the pull. It
+// returns the groups of the images to pull.
+func (opts *UpdaterOptions) prePullHooks(run *updateRun, serviceNames []string, states []*ServiceState) []imageGroup {
+	opts.forEach(len(serviceNames), func(i int) {
+		name, state := serviceNames[i], states[i]
+		if state == nil || len(opts.service(name).Hooks[HookPrePull]) == 0 {
+			return
+		}
+		sw := opts.NewSpinnerWrapper(fmt.Sprintf("Preparing %s", name))
+		sw.Start()
+		if err := opts.runHooks(name, HookPrePull, state, sw); err != nil {
+			sw.Stop(fmt.Sprintf("❌ %s hook of %s failed", HookPrePull, name))
+			opts.fail(run, name, state, err)
+			states[i] = nil
+			return
+		}
+		sw.Stop(fmt.Sprintf("🪝 Ran %s hooks of %s", HookPrePull, name))
+	})
+	return groupByImage(states)
+}
+
+// pullImages pulls each image once, through the first service using it, with a single
+// batched pull. If that fails, the images are pulled separately and concurrently to find
+// out which of them fail. A batched pull that timed out as a whole isn't repeated image by
+// image, which could take as long again; all of its images fail. Transient failures are
+// retried with backoff. It records the attempts in the states of the services and
+// returns the pull errors by service name.
+func (opts *UpdaterOptions) pullImages(serviceNames []string, states []*ServiceState, groups []imageGroup) map[string]error {
+	sw := opts.NewSpinnerWrapper(fmt.Sprintf("Pulling %d image(s)", len(groups)))
+	sw.Start()
+
+	pullFor := make([]string, len(groups))
+	timeouts := make([]time.Duration, len(groups))
+	var allNames []string
+	for i, group := range groups {
+		pullFor[i] = serviceNames[group.services[0]]
+		for _, service := range group.services {
+			allNames = append(allNames, serviceNames[service])
+		}
+		timeouts[i] = opts.longestPullTimeout(allNames[len(allNames)-len(group.services):])
+	}
+
+	attempts := make([]int, len(groups))
+	groupErrs := make([]error, len(groups))
+	batchErr := opts.Compose.PullContainers(pullFor, opts.longestPullTimeout(allNames))
+	var batchTimeout *compose.BatchTimeoutError
+	if batchErr == nil || errors.As(batchErr, &batchTimeout) {
+		for i := range groups {
+			attempts[i], groupErrs[i] = 1, batchErr
+		}
+	} else {
+		policy := opts.retryPolicy()
+		var swMu sync.Mutex
+		opts.forEach(len(groups), func(i int) {
+			attempt := 0
+			pull := func() error {
+				attempt++
+				swMu.Lock()
+				sw.UpdateSuffix(fmt.Sprintf("Pulling %s (attempt %d of %d)", groups[i].image, attempt, policy.Attempts))
+				swMu.Unlock()
+				return opts.Compose.PullContainer(pullFor[i], timeouts[i])
+			}
+
+			// The batched pull was the first attempt of a single image, several images
+			// are pulled once more on their own first
+			err := batchErr
+			if len(groups) == 1 {
+				attempt = 1
+			} else {
+				err = pull()
+			}
+			attempts[i], groupErrs[i] = retry.Continue(opts.retryContext(), policy, attempt, err, pull)
+		})
+	}
+
+	errs := make(map[string]error)
+	failed := 0
+	for i, group := range groups {
+		for _, service := range group.services {
+			states[service].PullAttempts = attempts[i]
+		}
+		err := groupErrs[i]
+		if err == nil {
+			continue
+		}
+		failed++
+		if attempts[i] > 1 {
+			err = fmt.Errorf("gave up after %d attempts: %w", attempts[i], err)
+		}
+		for _, service := range group.services {
+			errs[serviceNames[service]] = fmt.Errorf("failed to pull image %s for %s: %w", group.image, serviceNames[service], err)
+		}
+	}
+	if failed == len(groups) && len(groups) == 1 {
+		sw.Stop(fmt.Sprintf("❌ Failed to pull %s", groups[0].image))
+	} else {
+		sw.Stop(fmt.Sprintf("📥 Pulled %d of %d image(s)", len(groups)-failed, len(groups)))
+	}
+	return errs
+}
+
+// longestPullTimeout returns the longest pull timeout of several services pulled together,
+// 0 if any of them has no limit
+func (opts *UpdaterOptions) longestPullTimeout(serviceNames []string) time.Duration {
+	var longest time.Duration
+	for _, name := range serviceNames {
+		timeout := opts.pullTimeoutFor(name)
+		if timeout <= 0 {
+			return 0
+		}
+		longest = max(longest, timeout)
+	}
+	return longest
+}
+
+// restartPhase installs the pulled images, at most Concurrency services at a time. Once
+// the run is interrupted, services whose update hasn't started are left alone while the
+// running updates finish.
+func (opts *UpdaterOptions) restartPhase(run *updateRun, services []pulledService) {
+	opts.forEach(len(services), func(i int) {
+		service := services[i]
+		if opts.stopping() {
+			opts.interrupted(service.name, service.state, fmt.Sprintf("update to %s not installed, still runs %s",
+				report.ShortID(service.state.LatestImageID), report.ShortID(service.state.CurrentImageID)))
+			return
+		}
+		sw := opts.NewSpinnerWrapper(fmt.Sprintf("Updating %s", service.name))
+		sw.Start()
+
+		status, err := opts.installUpdate(service.name, service.state, sw)
+		if status == report.StatusFailed {
+			opts.fail(run, service.name, service.state, err)
+			return
+		}
+		opts.recordResult(service.name, status, service.state, nil)
+	})
+}
+
+// stageUpdate reports a pulled image that is left for a later run to install
+func (opts *UpdaterOptions) stageUpdate(service pulledService) {
+	_, service.state.HoldReason = opts.hold(service.name, service.state)
+	opts.println(fmt.Sprintf("📦 Pulled %s, ready to install", describe(service.name, service.state)))
+	opts.recordResult(service.name, report.StatusAvailable, service.state, nil)
+}
+
+// println prints a line of output, labelled like the spinner messages
+func (opts *UpdaterOptions) println(message string) {
+	sw := SpinnerWrapper{label: opts.Label}
+	fmt.Println(sw.labelled(message))
+}
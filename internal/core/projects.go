@@ -0,0 +1,128 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"dc-update/internal/compose"
+	"dc-update/internal/report"
+)
+
+// DefaultProjectConcurrency is the number of projects updated at once in multi-project mode
+const DefaultProjectConcurrency = 2
+
+// ForProject opens another compose project with the same Docker client and settings.
+// The returned options have their own report and label their output with the project name.
+func (opts *UpdaterOptions) ForProject(composeConfig compose.Config) (*UpdaterOptions, error) {
+	composeBackend, err := compose.NewBackend(composeConfig, opts.DockerClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpdaterOptions{
+		ShowWarnings:     opts.ShowWarnings,
+		UseSpinners:      opts.UseSpinners,
+		Compose:          composeBackend,
+		DockerClient:     opts.DockerClient,
+		Report:           report.New(composeBackend.ProjectName()),
+		Prune:            opts.Prune,
+		PruneKeep:        opts.PruneKeep,
+		Strategy:         opts.Strategy,
+		RollingBatchSize: opts.RollingBatchSize,
+		RollingPause:     opts.RollingPause,
+		HealthTimeout:    opts.HealthTimeout,
+		Label:            composeBackend.ProjectName(),
+		Concurrency:      opts.Concurrency,
+		Services:         opts.Services,
+		Backup:           opts.Backup,
+		BackupDir:        opts.BackupDir,
+		BackupKeep:       opts.BackupKeep,
+		BackupMaxAge:     opts.BackupMaxAge,
+		BackupImage:      opts.BackupImage,
+		Windows:          opts.Windows,
+		State:            opts.State,
+		MinAge:           opts.MinAge,
+		PullOnly:         opts.PullOnly,
+		Stop:             opts.Stop,
+		PullTimeout:      opts.PullTimeout,
+		StopTimeout:      opts.StopTimeout,
+		StartTimeout:     opts.StartTimeout,
+		PullRetries:      opts.PullRetries,
+		HubRateLimit:     opts.HubRateLimit,
+		hubBudget:        opts.hubBudget,
+	}, nil
+}
+
+// qualified returns the service name prefixed with the label, e.g. "blog/db"
+func (opts *UpdaterOptions) qualified(serviceName string) string {
+	if opts.Label == "" {
+		return serviceName
+	}
+	return opts.Label + "/" + serviceName
+}
+
+// UpdateProject builds the services configured with build, updates all services of the
+// project that aren't skipped and finishes its report
+func (opts *UpdaterOptions) UpdateProject() error {
+	defer opts.Report.Finish()
+
+	serviceNames, err := opts.Compose.GetServiceNames()
+	if err != nil {
+		opts.Report.Err = fmt.Errorf("failed to get service names: %w", err)
+		return opts.Report.Err
+	}
+	if err := opts.Build(opts.BuildList(nil)); err != nil {
+		opts.Report.Err = err
+		return err
+	}
+	return opts.UpdateContainersConcurrently(opts.SelectServices(serviceNames))
+}
+
+// UpdateProjects updates several projects, at most concurrency at a time. done is called
+// with each project as soon as it has finished. Once the run is interrupted, the projects
+// that haven't started are skipped.
+func UpdateProjects(projects []*UpdaterOptions, concurrency int, done func(project *UpdaterOptions)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+
+	for _, project := range projects {
+		wg.Add(1)
+		go func(project *UpdaterOptions) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			// Projects not started before an interrupt are left alone and not notified
+			if project.stopping() {
+				project.Report.Err = errInterrupted
+				project.Report.Finish()
+				return
+			}
+
+			err := project.UpdateProject()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+			}
+			if done != nil {
+				done(project)
+			}
+		}(project)
+	}
+
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d projects had failures", failed, len(projects))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"dc-update/internal/docker"
+)
+
+// pruneImages removes the superseded images of a service once all of its replicas are
+// confirmed running the new image. Images still used by any container are never removed,
+// and the newest PruneKeep superseded images of the repository are kept for rollback.
+func (opts *UpdaterOptions) pruneImages(serviceName string, state *ServiceState) ([]string, error) {
+	// Services sharing a repository must not race each other removing the same images
+	opts.pruneMu.Lock()
+	defer opts.pruneMu.Unlock()
+
+	if len(state.Replicas) == 0 {
+		return nil, fmt.Errorf("%s is not running after the update, keeping previous image", serviceName)
+	}
+	for _, replica := range state.Replicas {
+		if !replica.IsRunning() || replica.ImageID != state.LatestImageID {
+			return nil, fmt.Errorf("%s is not running the new image, keeping previous image", replica.Name)
+		}
+	}
+
+	inUse, err := opts.DockerClient.ImagesInUse()
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := opts.DockerClient.RepositoryImages(docker.RepositoryName(state.ImageName))
+	if err != nil {
+		return nil, err
+	}
+
+	// Superseded images are the service's previous image and any untagged image of
+	// the same repository, newest first
+	var superseded []string
+	for _, image := range images {
+		imageID := strings.TrimPrefix(image.ID, "sha256:")
+		if inUse[imageID] {
+			continue
+		}
+		dangling := len(image.RepoTags) == 0 || (len(image.RepoTags) == 1 && image.RepoTags[0] == "<none>:<none>")
+		if dangling || imageID == state.CurrentImageID {
+			superseded = append(superseded, imageID)
+		}
+	}
+
+	keep := max(opts.PruneKeep, 0)
+	if len(superseded) <= keep {
+		return nil, nil
+	}
+
+	var pruned []string
+	var errs []error
+	for _, imageID := range superseded[keep:] {
+		if err := opts.DockerClient.RemoveImage(imageID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pruned = append(pruned, imageID)
+	}
+
+	return pruned, errors.Join(errs...)
+}
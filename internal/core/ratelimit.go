@@ -0,0 +1,142 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"dc-update/internal/registry"
+	"dc-update/internal/report"
+)
+
+// What to do when fewer Docker Hub pulls are left than images to pull
+const (
+	// HubRateLimitWarn pulls anyway and warns about it
+	HubRateLimitWarn = "warn"
+	// HubRateLimitDefer pulls as many images as the budget allows and defers the rest
+	HubRateLimitDefer = "defer"
+	// HubRateLimitOff doesn't check the budget
+	HubRateLimitOff = "off"
+)
+
+// hubGroups returns the indexes of the groups whose images are pulled from Docker Hub
+func hubGroups(groups []imageGroup) []int {
+	var hub []int
+	for i, group := range groups {
+		if registry.Domain(group.image) == registry.DockerHub {
+			hub = append(hub, i)
+		}
+	}
+	return hub
+}
+
+// hubBudget is the Docker Hub pull budget of a run, shared by all of its projects. It is
+// checked once, when the first project is about to pull from Docker Hub, and counted down
+// by the pulls each project starts, so concurrent projects don't spend the same pulls.
+type hubBudget struct {
+	mu      sync.Mutex
+	checked bool
+	limit   *registry.RateLimit // nil if Docker Hub reports no limit or it wasn't checked
+	claimed int                 // pulls started since the check
+}
+
+// claim returns the budget left before the caller's pulls and reserves pulls of them, the
+// limit is checked with check first if it hasn't been yet. It returns nil if there is no
+// known limit.
+func (b *hubBudget) claim(pulls int, check func() *registry.RateLimit) *registry.RateLimit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.checked {
+		b.limit = check()
+		b.checked = true
+	}
+	if b.limit == nil {
+		return nil
+	}
+	left := *b.limit
+	left.Remaining -= b.claimed
+	b.claimed += min(pulls, max(left.Remaining, 0))
+	return &left
+}
+
+// extend reserves pulls beyond the budget, pulled anyway with HubRateLimitWarn
+func (b *hubBudget) extend(pulls int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.claimed += pulls
+}
+
+// checkHubRateLimit returns the Docker Hub pull budget of the credentials the images are
+// pulled with, nil if Docker Hub reports no limit or it can't be checked. An anonymous
+// budget is that of this host's IP address, so it isn't checked for a remote daemon,
+// which pulls from its own address.
+func (opts *UpdaterOptions) checkHubRateLimit() *registry.RateLimit {
+	auth := opts.DockerClient.RegistryAuth()
+	if opts.DockerClient.IsRemote() {
+		creds, err := auth.Credentials(registry.DockerHub)
+		if err == nil && creds.Anonymous() {
+			fmt.Fprintf(os.Stderr, "Warning: not checking the Docker Hub rate limit, the anonymous limit of %s applies to its own IP address\n", opts.DockerClient.Host())
+			return nil
+		}
+	}
+
+	limit, err := registry.NewClient(auth).CheckHubRateLimit(opts.retryContext())
+	if err != nil {
+		opts.println(fmt.Sprintf("⚠️  %v", err))
+		return nil
+	}
+	return limit
+}
+
+// limitHubPulls claims pulls from the Docker Hub pull budget of the run before the images
+// of groups are pulled and records the budget left in the report. Fewer pulls left than
+// images to pull from Docker Hub are warned about, or with HubRateLimitDefer, the services
+// of the images beyond the budget are deferred and left out of the pull phase. It returns
+// the groups to pull.
+func (opts *UpdaterOptions) limitHubPulls(serviceNames []string, states []*ServiceState, groups []imageGroup) []imageGroup {
+	if opts.HubRateLimit == HubRateLimitOff {
+		return groups
+	}
+	hub := hubGroups(groups)
+	if len(hub) == 0 {
+		return groups
+	}
+
+	limit := opts.hubBudget.claim(len(hub), opts.checkHubRateLimit)
+	if limit == nil {
+		return groups
+	}
+	left := *limit
+	left.Remaining = max(limit.Remaining-len(hub), 0)
+	opts.Report.HubRateLimit = &left
+	if limit.Remaining >= len(hub) {
+		return groups
+	}
+	if opts.HubRateLimit != HubRateLimitDefer {
+		opts.hubBudget.extend(len(hub) - max(limit.Remaining, 0))
+		opts.println(fmt.Sprintf("⚠️  Docker Hub: %s, %d image(s) to pull", limit, len(hub)))
+		return groups
+	}
+
+	// The images listed first are pulled, the others wait for a later run
+	deferred := make(map[int]bool)
+	for _, g := range hub[max(limit.Remaining, 0):] {
+		deferred[g] = true
+	}
+	reason := fmt.Sprintf("Docker Hub pull limit, %s", limit)
+	var kept []imageGroup
+	for g, group := range groups {
+		if !deferred[g] {
+			kept = append(kept, group)
+			continue
+		}
+		for _, service := range group.services {
+			name, state := serviceNames[service], states[service]
+			state.HoldReason = reason
+			opts.println(fmt.Sprintf("⏸️  Deferred %s: %s", name, reason))
+			opts.recordResult(name, report.StatusDeferred, state, nil)
+			states[service] = nil
+		}
+	}
+	return kept
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Update strategies for services with several replicas
+const (
+	// StrategyRecreate stops and removes all replicas, then starts them again
+	StrategyRecreate = "recreate"
+	// StrategyRolling replaces replicas in batches, keeping the service available
+	StrategyRolling = "rolling"
+)
+
+// Defaults for rolling updates
+const (
+	DefaultRollingBatchSize = 1
+	DefaultHealthTimeout    = 2 * time.Minute
+)
+
+// useRollingUpdate reports whether a service should be updated replica by replica
+func (opts *UpdaterOptions) useRollingUpdate(serviceName string, state *ServiceState) bool {
+	return opts.strategyFor(serviceName) == StrategyRolling && len(state.ContainerIDs) > 1
+}
+
+// RollingRestart replaces the outdated replicas of a service in batches. For each batch it
+// starts new replicas on the new image, waits until they are healthy, then stops and removes
+// as many old replicas, so the service keeps running throughout the update.
+func (opts *UpdaterOptions) RollingRestart(serviceName string, state *ServiceState, sw *SpinnerWrapper) error {
+	batchSize := opts.rollingBatchSizeFor(serviceName)
+	healthTimeout := opts.healthTimeoutFor(serviceName)
+	startTimeout := opts.startTimeoutFor(serviceName)
+
+	var outdated []string
+	for _, replica := range state.Replicas {
+		if replica.ImageID != state.LatestImageID {
+			outdated = append(outdated, replica.ID)
+		}
+	}
+
+	replicas := len(state.ContainerIDs)
+	known := append([]string{}, state.ContainerIDs...)
+
+	for start := 0; start < len(outdated); start += batchSize {
+		batch := outdated[start:min(start+batchSize, len(outdated))]
+		sw.UpdateSuffix(fmt.Sprintf("Rolling update of %s (%d/%d replicas)", serviceName, start+len(batch), len(outdated)))
+
+		// Start new replicas alongside the old ones
+		if err := opts.Compose.ScaleService(serviceName, replicas+len(batch), startTimeout); err != nil {
+			return err
+		}
+
+		containerIDs, err := opts.Compose.GetCurrentContainerIds(serviceName)
+		if err != nil {
+			return fmt.Errorf("failed to get container IDs for %s: %w", serviceName, err)
+		}
+
+		var started []string
+		for _, containerID := range containerIDs {
+			if !slices.Contains(known, containerID) {
+				started = append(started, containerID)
+				known = append(known, containerID)
+			}
+		}
+
+		for _, containerID := range started {
+			if err := opts.DockerClient.WaitHealthy(containerID, healthTimeout); err != nil {
+				// Leave the old replicas serving and drop the broken new ones
+				err = fmt.Errorf("rolling update of %s aborted: %w", serviceName, err)
+				return errors.Join(err, opts.retireReplicas(serviceName, started))
+			}
+		}
+
+		// Retire the same number of old replicas
+		if err := opts.retireReplicas(serviceName, batch); err != nil {
+			err = fmt.Errorf("rolling update of %s failed to retire old replicas: %w", serviceName, err)
+			return errors.Join(err, opts.restoreReplicas(serviceName, replicas, startTimeout))
+		}
+
+		if opts.RollingPause > 0 && start+batchSize < len(outdated) {
+			time.Sleep(opts.RollingPause)
+		}
+	}
+
+	return nil
+}
+
+// retireReplicas stops and removes replicas of a service. A replica that fails doesn't
+// keep the others from being retired, the errors of all of them are returned.
+func (opts *UpdaterOptions) retireReplicas(serviceName string, containerIDs []string) error {
+	var errs []error
+	for _, containerID := range containerIDs {
+		if err := opts.stopContainer(serviceName, containerID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := opts.DockerClient.RemoveContainer(containerID); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove container %s: %w", containerID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreReplicas starts replicas again until the service has as many running as before
+// the update, after retiring old replicas failed part way
+func (opts *UpdaterOptions) restoreReplicas(serviceName string, replicas int, timeout time.Duration) error {
+	containerIDs, err := opts.Compose.GetCurrentContainerIds(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get container IDs for %s: %w", serviceName, err)
+	}
+	if len(containerIDs) >= replicas {
+		return nil
+	}
+	if err := opts.Compose.ScaleService(serviceName, replicas, timeout); err != nil {
+		return fmt.Errorf("failed to restore %d replicas of %s: %w", replicas, serviceName, err)
+	}
+	return nil
+}
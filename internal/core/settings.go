@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"dc-update/internal/config"
+	"dc-update/internal/retry"
+	"dc-update/internal/schedule"
+)
+
+// DefaultConcurrency is the number of services updated at once
+const DefaultConcurrency = 3
+
+// Default time limits of the compose operations of an update
+const (
+	DefaultPullTimeout  = 10 * time.Minute
+	DefaultStopTimeout  = 5 * time.Minute
+	DefaultStartTimeout = 5 * time.Minute
+)
+
+// service returns the configured overrides of a service
+func (opts *UpdaterOptions) service(serviceName string) config.Service {
+	return opts.Services[serviceName]
+}
+
+// strategyFor returns the update strategy of a service
+func (opts *UpdaterOptions) strategyFor(serviceName string) string {
+	if strategy := opts.service(serviceName).Strategy; strategy != "" {
+		return strategy
+	}
+	return opts.Strategy
+}
+
+// pruneFor reports whether superseded images of a service are pruned
+func (opts *UpdaterOptions) pruneFor(serviceName string) bool {
+	if prune := opts.service(serviceName).Prune; prune != nil {
+		return *prune
+	}
+	return opts.Prune
+}
+
+// rollingBatchSizeFor returns the rolling update batch size of a service
+func (opts *UpdaterOptions) rollingBatchSizeFor(serviceName string) int {
+	if size := opts.service(serviceName).RollingBatchSize; size > 0 {
+		return size
+	}
+	if opts.RollingBatchSize > 0 {
+		return opts.RollingBatchSize
+	}
+	return DefaultRollingBatchSize
+}
+
+// minAgeFor returns how old a new image of a service must be before it is installed
+func (opts *UpdaterOptions) minAgeFor(serviceName string) time.Duration {
+	if minAge := opts.service(serviceName).MinAge; minAge > 0 {
+		return minAge
+	}
+	return opts.MinAge
+}
+
+// healthTimeoutFor returns how long to wait for new replicas of a service to become healthy
+func (opts *UpdaterOptions) healthTimeoutFor(serviceName string) time.Duration {
+	if timeout := opts.service(serviceName).HealthTimeout; timeout > 0 {
+		return timeout
+	}
+	if opts.HealthTimeout > 0 {
+		return opts.HealthTimeout
+	}
+	return DefaultHealthTimeout
+}
+
+// pullTimeoutFor returns how long pulling the image of a service may take
+func (opts *UpdaterOptions) pullTimeoutFor(serviceName string) time.Duration {
+	if timeout := opts.service(serviceName).PullTimeout; timeout > 0 {
+		return timeout
+	}
+	return opts.PullTimeout
+}
+
+// stopTimeoutFor returns how long stopping the containers of a service may take
+func (opts *UpdaterOptions) stopTimeoutFor(serviceName string) time.Duration {
+	if timeout := opts.service(serviceName).StopTimeout; timeout > 0 {
+		return timeout
+	}
+	return opts.StopTimeout
+}
+
+// startTimeoutFor returns how long starting the containers of a service may take
+func (opts *UpdaterOptions) startTimeoutFor(serviceName string) time.Duration {
+	if timeout := opts.service(serviceName).StartTimeout; timeout > 0 {
+		return timeout
+	}
+	return opts.StartTimeout
+}
+
+// retryPolicy returns how failed pulls are retried
+func (opts *UpdaterOptions) retryPolicy() retry.Policy {
+	policy := retry.DefaultPolicy()
+	policy.Attempts = max(opts.PullRetries, 0) + 1
+	return policy
+}
+
+// retryContext returns the context that ends waiting for retries: the run's interrupt,
+// or the cancellation of all operations
+func (opts *UpdaterOptions) retryContext() context.Context {
+	if opts.Stop != nil {
+		return opts.Stop
+	}
+	return opts.DockerClient.Context()
+}
+
+// ValidateServices checks the per-service overrides
+func (opts *UpdaterOptions) ValidateServices() error {
+	for name, service := range opts.Services {
+		switch service.Strategy {
+		case "", StrategyRecreate, StrategyRolling:
+		default:
+			return fmt.Errorf("service %s: unknown update strategy '%s' (expected recreate or rolling)", name, service.Strategy)
+		}
+		if err := validateHooks(name, service.Hooks); err != nil {
+			return err
+		}
+		if _, err := schedule.ParseWindows(service.MaintenanceWindows); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// SelectServices returns the services to update, leaving out those configured with skip
+func (opts *UpdaterOptions) SelectServices(serviceNames []string) []string {
+	selected := make([]string, 0, len(serviceNames))
+	for _, name := range serviceNames {
+		if opts.service(name).Skip {
+			fmt.Printf("⏭️  Skipping %s (skip is set in the configuration)\n", opts.qualified(name))
+			continue
+		}
+		selected = append(selected, name)
+	}
+	return selected
+}
+
+// BuildList returns the services to build: those given plus those configured with build in
+// name order, without duplicates
+func (opts *UpdaterOptions) BuildList(serviceNames []string) []string {
+	seen := make(map[string]bool)
+	var build []string
+	for _, name := range serviceNames {
+		if !seen[name] {
+			seen[name] = true
+			build = append(build, name)
+		}
+	}
+	var configured []string
+	for name, service := range opts.Services {
+		if service.Build && !seen[name] {
+			configured = append(configured, name)
+		}
+	}
+	sort.Strings(configured)
+	return append(build, configured...)
+}
+
+// Build builds the given services
+func (opts *UpdaterOptions) Build(serviceNames []string) error {
+	if len(serviceNames) == 0 {
+		return nil
+	}
+	fmt.Printf("Building containers: %v\n", serviceNames)
+	if err := opts.Compose.BuildContainers(serviceNames); err != nil {
+		return fmt.Errorf("failed to build containers: %w", err)
+	}
+	return nil
+}
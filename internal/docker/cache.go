@@ -0,0 +1,176 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"golang.org/x/sync/singleflight"
+)
+
+// imageCache maps image references to the local images they point to. It is safe for
+// concurrent use, and concurrent lookups of the same images share one API call.
+type imageCache struct {
+	mu     sync.RWMutex
+	loaded bool
+	byRef  map[string]*types.ImageSummary
+	flight singleflight.Group
+
+	refreshes uint64            // number of refreshes started so far
+	refreshed map[string]uint64 // refresh whose listing is cached, by normalized reference
+}
+
+// containerCache holds container inspections by container ID. It is safe for concurrent
+// use, and concurrent inspections of the same container share one API call.
+type containerCache struct {
+	mu     sync.RWMutex
+	byID   map[string]*types.ContainerJSON
+	flight singleflight.Group
+}
+
+// populateImageCache loads all images into cache for faster lookups
+func (c *Client) populateImageCache() error {
+	c.images.mu.RLock()
+	loaded := c.images.loaded
+	c.images.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	_, err, _ := c.images.flight.Do("all", func() (interface{}, error) {
+		images, err := c.cli.ImageList(c.ctx, types.ImageListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Docker images: %w", err)
+		}
+
+		// Pre-allocate cache with estimated capacity
+		byRef := make(map[string]*types.ImageSummary, len(images)*2)
+		addImages(byRef, images)
+
+		c.images.mu.Lock()
+		c.images.byRef = byRef
+		c.images.loaded = true
+		c.images.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+// addImages adds every tag of the images to a cache map
+func addImages(byRef map[string]*types.ImageSummary, images []types.ImageSummary) {
+	for _, image := range images {
+		for _, repoTag := range image.RepoTags {
+			if repoTag != "<none>:<none>" {
+				imageCopy := image // Create copy to avoid pointer issues
+				byRef[repoTag] = &imageCopy
+			}
+		}
+	}
+}
+
+// cachedImage returns the cached image of a reference, also looking it up under the
+// short name docker lists it by
+func (c *Client) cachedImage(imageName string) (*types.ImageSummary, bool) {
+	c.images.mu.RLock()
+	defer c.images.mu.RUnlock()
+	if image, exists := c.images.byRef[imageName]; exists {
+		return image, true
+	}
+	image, exists := c.images.byRef[NormalizeImageRef(imageName)]
+	return image, exists
+}
+
+// RefreshImages updates the cached images of the given references, e.g. after pulling
+// them, without listing every other image again. Each caller lists the images itself, as a
+// listing started before its pull finished could miss the pulled image, and a listing
+// never replaces one that started later.
+func (c *Client) RefreshImages(imageNames []string) error {
+	if err := c.populateImageCache(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(imageNames))
+	for _, imageName := range imageNames {
+		ref := NormalizeImageRef(imageName)
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		c.images.mu.Lock()
+		c.images.refreshes++
+		refresh := c.images.refreshes
+		c.images.mu.Unlock()
+
+		images, err := c.cli.ImageList(c.ctx, types.ImageListOptions{
+			Filters: filters.NewArgs(filters.Arg("reference", ref)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list Docker images for %s: %w", ref, err)
+		}
+
+		c.images.mu.Lock()
+		if c.images.refreshed == nil {
+			c.images.refreshed = make(map[string]uint64)
+		}
+		if refresh > c.images.refreshed[ref] {
+			c.images.refreshed[ref] = refresh
+			delete(c.images.byRef, imageName)
+			delete(c.images.byRef, ref)
+			addImages(c.images.byRef, images)
+		}
+		c.images.mu.Unlock()
+	}
+	return nil
+}
+
+// forgetImage drops every reference to an image from the image cache
+func (c *Client) forgetImage(imageID string) {
+	imageID = strings.TrimPrefix(imageID, "sha256:")
+
+	c.images.mu.Lock()
+	defer c.images.mu.Unlock()
+	for ref, image := range c.images.byRef {
+		if strings.TrimPrefix(image.ID, "sha256:") == imageID {
+			delete(c.images.byRef, ref)
+		}
+	}
+}
+
+// getContainerInspection gets container info with caching
+func (c *Client) getContainerInspection(containerID string) (*types.ContainerJSON, error) {
+	c.containers.mu.RLock()
+	cached, exists := c.containers.byID[containerID]
+	c.containers.mu.RUnlock()
+	if exists {
+		return cached, nil
+	}
+
+	result, err, _ := c.containers.flight.Do(containerID, func() (interface{}, error) {
+		containerJSON, err := c.cli.ContainerInspect(c.ctx, containerID)
+		if err != nil {
+			if strings.Contains(err.Error(), "No such container") {
+				return nil, fmt.Errorf("container %s does not exist or is not running", containerID)
+			}
+			return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+		}
+
+		c.containers.mu.Lock()
+		c.containers.byID[containerID] = &containerJSON
+		c.containers.mu.Unlock()
+		return &containerJSON, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*types.ContainerJSON), nil
+}
+
+// forgetContainer drops a container from the container cache
+func (c *Client) forgetContainer(containerID string) {
+	c.containers.mu.Lock()
+	defer c.containers.mu.Unlock()
+	delete(c.containers.byID, containerID)
+}
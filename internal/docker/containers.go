@@ -0,0 +1,265 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dc-update/internal/registry"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Labels docker compose sets on the resources it creates
+const (
+	LabelComposeProject     = "com.docker.compose.project"
+	LabelComposeService     = "com.docker.compose.service"
+	LabelComposeNumber      = "com.docker.compose.container-number"
+	LabelComposeOneOff      = "com.docker.compose.oneoff"
+	LabelComposeWorkingDir  = "com.docker.compose.project.working_dir"
+	LabelComposeConfigFiles = "com.docker.compose.project.config_files"
+	LabelComposeNetwork     = "com.docker.compose.network"
+	LabelComposeVolume      = "com.docker.compose.volume"
+	LabelComposeVersion     = "com.docker.compose.version"
+	LabelComposeConfigHash  = "com.docker.compose.config-hash"
+)
+
+// ListServiceContainers lists the containers of a compose service, excluding one-off
+// containers. Stopped containers are only included if all is set.
+func (c *Client) ListServiceContainers(project string, service string, all bool) ([]types.Container, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", fmt.Sprintf("%s=%s", LabelComposeProject, project)),
+		filters.Arg("label", fmt.Sprintf("%s=%s", LabelComposeService, service)),
+		filters.Arg("label", fmt.Sprintf("%s=False", LabelComposeOneOff)),
+	)
+
+	containers, err := c.cli.ContainerList(c.ctx, types.ContainerListOptions{All: all, Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers of %s: %w", service, err)
+	}
+	return containers, nil
+}
+
+// ComposeProject describes a compose project as recorded in the labels of its containers
+type ComposeProject struct {
+	Name        string
+	WorkingDir  string
+	ConfigFiles []string
+}
+
+// ListComposeProjects returns the compose projects that have running containers, sorted
+// by name. One-off containers, e.g. from `docker compose run`, are ignored.
+func (c *Client) ListComposeProjects() ([]ComposeProject, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", LabelComposeProject),
+		filters.Arg("label", fmt.Sprintf("%s=False", LabelComposeOneOff)),
+	)
+
+	containers, err := c.cli.ContainerList(c.ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose containers: %w", err)
+	}
+
+	projects := make(map[string]*ComposeProject)
+	for _, ctr := range containers {
+		name := ctr.Labels[LabelComposeProject]
+		if _, ok := projects[name]; ok {
+			continue
+		}
+
+		project := &ComposeProject{
+			Name:       name,
+			WorkingDir: ctr.Labels[LabelComposeWorkingDir],
+		}
+		for _, file := range strings.Split(ctr.Labels[LabelComposeConfigFiles], ",") {
+			if file = strings.TrimSpace(file); file == "" {
+				continue
+			}
+			// docker-compose v1 records the files as given on its command line
+			if !filepath.IsAbs(file) && project.WorkingDir != "" {
+				file = filepath.Join(project.WorkingDir, file)
+			}
+			project.ConfigFiles = append(project.ConfigFiles, file)
+		}
+		projects[name] = project
+	}
+
+	result := make([]ComposeProject, 0, len(projects))
+	for _, project := range projects {
+		result = append(result, *project)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// CreateContainer creates a container and returns its ID
+func (c *Client) CreateContainer(name string, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig) (string, error) {
+	created, err := c.cli.ContainerCreate(c.ctx, config, hostConfig, networkingConfig, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container %s: %w", name, err)
+	}
+	return created.ID, nil
+}
+
+// StartContainer starts a created or stopped container
+func (c *Client) StartContainer(containerID string) error {
+	if err := c.cli.ContainerStart(c.ctx, containerID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// ConnectNetwork attaches a container to an additional network
+func (c *Client) ConnectNetwork(networkName string, containerID string, endpoint *network.EndpointSettings) error {
+	if err := c.cli.NetworkConnect(c.ctx, networkName, containerID, endpoint); err != nil {
+		return fmt.Errorf("failed to connect container %s to network %s: %w", containerID, networkName, err)
+	}
+	return nil
+}
+
+// EnsureNetwork creates a network unless one with the same name already exists
+func (c *Client) EnsureNetwork(name string, options types.NetworkCreate) error {
+	if _, err := c.cli.NetworkInspect(c.ctx, name, types.NetworkInspectOptions{}); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+
+	options.CheckDuplicate = true
+	if _, err := c.cli.NetworkCreate(c.ctx, name, options); err != nil {
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return nil
+}
+
+// EnsureVolume creates a volume unless one with the same name already exists
+func (c *Client) EnsureVolume(options volumetypes.VolumeCreateBody) error {
+	if _, err := c.cli.VolumeInspect(c.ctx, options.Name); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect volume %s: %w", options.Name, err)
+	}
+
+	if _, err := c.cli.VolumeCreate(c.ctx, options); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", options.Name, err)
+	}
+	return nil
+}
+
+// PullImage pulls an image through the Engine API and waits for the pull to finish
+func (c *Client) PullImage(imageRef string) error {
+	registryAuth, err := c.encodedAuth(imageRef)
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", imageRef, err)
+	}
+	stream, err := c.cli.ImagePull(c.ctx, imageRef, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", imageRef, err)
+	}
+	defer stream.Close()
+
+	// The pull only completes once the progress stream is drained, and errors
+	// that happen mid-pull are only reported inside the stream
+	decoder := json.NewDecoder(stream)
+	for {
+		var msg jsonmessage.JSONMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("failed to read pull progress for %s: %w", imageRef, err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("failed to pull image %s: %w", imageRef, msg.Error)
+		}
+	}
+
+	return nil
+}
+
+// encodedAuth returns the credentials of an image's registry in the form the Engine API
+// expects, "" to pull anonymously
+func (c *Client) encodedAuth(imageRef string) (string, error) {
+	domain := registry.Domain(imageRef)
+	creds := c.auth.PullCredentials(domain)
+	if creds.Anonymous() {
+		return "", nil
+	}
+	data, err := json.Marshal(types.AuthConfig{
+		Username:      creds.Username,
+		Password:      creds.Password,
+		IdentityToken: creds.IdentityToken,
+		ServerAddress: registry.ServerAddress(domain),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// ExecResult is the outcome of a command run inside a container
+type ExecResult struct {
+	Output   string // combined stdout and stderr
+	ExitCode int
+}
+
+// Exec runs a command inside a running container and waits for it to finish, at most
+// for timeout. A non-zero exit code is not an error.
+func (c *Client) Exec(containerID string, cmd []string, user string, env []string, timeout time.Duration) (*ExecResult, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+
+	created, err := c.cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		User:         user,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          env,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec in container %s: %w", containerID, err)
+	}
+
+	attach, err := c.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec in container %s: %w", containerID, err)
+	}
+	defer attach.Close()
+
+	// The hijacked connection doesn't follow the context, so close it on timeout
+	go func() {
+		<-ctx.Done()
+		attach.Close()
+	}()
+
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, attach.Reader); err != nil {
+		if ctx.Err() != nil {
+			return &ExecResult{Output: output.String(), ExitCode: -1}, fmt.Errorf("exec in container %s timed out after %s", containerID, timeout)
+		}
+		return nil, fmt.Errorf("failed to read exec output from container %s: %w", containerID, err)
+	}
+
+	inspect, err := c.cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect exec in container %s: %w", containerID, err)
+	}
+	return &ExecResult{Output: output.String(), ExitCode: inspect.ExitCode}, nil
+}
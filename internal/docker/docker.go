@@ -2,9 +2,17 @@ package docker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"dc-update/internal/registry"
+	"dc-update/internal/retry"
+
+	"github.com/distribution/reference"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 )
@@ -13,85 +21,193 @@ import (
 type Client struct {
 	cli        *client.Client
 	ctx        context.Context
-	imageCache map[string]*types.ImageSummary  // Cache for image lookups
-	containerCache map[string]*types.ContainerJSON // Cache for container inspections
+	engine     string          // EngineDocker or EnginePodman
+	endpoint   endpoint        // daemon the client is connected to
+	images     *imageCache     // Cache for image lookups, shared with derived clients
+	containers *containerCache // Cache for container inspections, shared with derived clients
+	timeout    time.Duration   // deadline of the client's API calls, set by WithTimeout
+	auth       *registry.Auth  // credentials images are pulled with
+}
+
+// Config selects the container engine and daemon to connect to
+type Config struct {
+	Engine  string         // EngineAuto, EngineDocker or EnginePodman
+	Host    string         // daemon address, e.g. "ssh://user@host" or "tcp://host:2376"
+	Context string         // docker context to take the daemon address from
+	Auth    *registry.Auth // credentials to pull images with, those of the docker CLI configuration if nil
 }
 
-// NewClient creates a new Docker API client
-func NewClient() (*Client, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// NewClient creates a new API client for the configured engine and daemon. Podman is
+// reached through its Docker-compatible API socket, ssh:// hosts through ssh. Cancelling
+// ctx cancels every running API call of the client.
+func NewClient(ctx context.Context, config Config) (*Client, error) {
+	engine, err := resolveEngine(config)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := resolveEndpoint(config, engine)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Docker daemon - is Docker running? %w", err)
+		return nil, err
+	}
+
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if ep.TLS != nil {
+		clientOpts = append(clientOpts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: ep.TLS},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+	if strings.HasPrefix(ep.Host, "ssh://") {
+		dialer, err := sshDialer(ctx, ep.Host, engine)
+		if err != nil {
+			return nil, err
+		}
+		// The host is only a placeholder, every connection goes through ssh
+		clientOpts = append(clientOpts, client.WithHost("http://docker.example.com"), client.WithDialContext(dialer))
+	} else {
+		clientOpts = append(clientOpts, client.WithHost(ep.Host))
+	}
+
+	auth := config.Auth
+	if auth == nil {
+		auth = registry.NewAuth(nil)
+	}
+
+	cli, err := client.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s at %s - is it running? %w", engineName(engine), ep.Host, err)
 	}
 
 	// Test the connection by pinging the daemon
-	ctx := context.Background()
 	_, err = cli.Ping(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Docker daemon is not responding - check Docker daemon status: %w", err)
+		return nil, fmt.Errorf("%s at %s is not responding - check its status: %w", engineName(engine), ep.Host, err)
 	}
 
 	return &Client{
 		cli:        cli,
 		ctx:        ctx,
-		imageCache: make(map[string]*types.ImageSummary),
-		containerCache: make(map[string]*types.ContainerJSON),
+		engine:     engine,
+		endpoint:   ep,
+		images:     &imageCache{},
+		containers: &containerCache{byID: make(map[string]*types.ContainerJSON)},
+		auth:       auth,
 	}, nil
 }
 
-// Close closes the Docker client connection
-func (c *Client) Close() error {
-	return c.cli.Close()
+// engineName returns a human readable name for the engine's daemon
+func engineName(engine string) string {
+	if engine == EnginePodman {
+		return "Podman API service"
+	}
+	return "Docker daemon"
+}
+
+// Engine returns the engine the client is connected to, EngineDocker or EnginePodman
+func (c *Client) Engine() string {
+	return c.engine
+}
+
+// Host returns the address of the daemon, e.g. "unix:///run/podman/podman.sock"
+func (c *Client) Host() string {
+	return c.endpoint.Host
 }
 
-// populateImageCache loads all images into cache for faster lookups
-func (c *Client) populateImageCache() error {
-	// Skip if cache is already populated
-	if len(c.imageCache) > 0 {
-		return nil
+// IsRemote reports whether the daemon runs on another host, reached over ssh or TCP
+func (c *Client) IsRemote() bool {
+	scheme, _, _ := strings.Cut(c.endpoint.Host, "://")
+	switch scheme {
+	case "ssh", "tcp", "http", "https":
+		return true
 	}
-	
-	images, err := c.cli.ImageList(c.ctx, types.ImageListOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to list Docker images: %w", err)
-	}
-	
-	// Pre-allocate cache with estimated capacity
-	estimatedCapacity := len(images) * 2 // Rough estimate for repo tags
-	c.imageCache = make(map[string]*types.ImageSummary, estimatedCapacity)
-	
-	// Populate cache with all image references
-	for _, image := range images {
-		for _, repoTag := range image.RepoTags {
-			if repoTag != "<none>:<none>" {
-				imageCopy := image // Create copy to avoid pointer issues
-				c.imageCache[repoTag] = &imageCopy
-			}
-		}
+	return false
+}
+
+// Env returns environment variables that point docker and compose commands at the
+// daemon the client is connected to
+func (c *Client) Env() []string {
+	return c.endpoint.Env()
+}
+
+// Context returns the context the client's API calls run with
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// RegistryAuth returns the credentials the client pulls images with
+func (c *Client) RegistryAuth() *registry.Auth {
+	return c.auth
+}
+
+// withContext returns a client on the same connection and caches whose API calls run
+// with ctx
+func (c *Client) withContext(ctx context.Context) *Client {
+	return &Client{
+		cli:        c.cli,
+		ctx:        ctx,
+		engine:     c.engine,
+		endpoint:   c.endpoint,
+		images:     c.images,
+		containers: c.containers,
+		auth:       c.auth,
 	}
-	
-	return nil
 }
 
-// getContainerInspection gets container info with caching
-func (c *Client) getContainerInspection(containerID string) (*types.ContainerJSON, error) {
-	// Check cache first
-	if cached, exists := c.containerCache[containerID]; exists {
-		return cached, nil
+// Detached returns a client on the same connection whose API calls aren't cancelled with
+// the client's context, e.g. to find out in which state an aborted update left a service
+func (c *Client) Detached() *Client {
+	return c.withContext(context.WithoutCancel(c.ctx))
+}
+
+// WithTimeout returns a client on the same connection whose API calls are cancelled once
+// timeout has passed, along with the function releasing it. A timeout of 0 means no limit.
+func (c *Client) WithTimeout(timeout time.Duration) (*Client, context.CancelFunc) {
+	if timeout <= 0 {
+		return c, func() {}
 	}
-	
-	// Not in cache, fetch from API
-	containerJSON, err := c.cli.ContainerInspect(c.ctx, containerID)
-	if err != nil {
-		if strings.Contains(err.Error(), "No such container") {
-			return nil, fmt.Errorf("container %s does not exist or is not running", containerID)
-		}
-		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	derived := c.withContext(ctx)
+	derived.timeout = timeout
+	return derived, cancel
+}
+
+// TimeoutError is the error of an operation that didn't finish within its time limit.
+// Like net.Error, it reports itself as a timeout.
+type TimeoutError struct {
+	Limit time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Limit)
+}
+
+// Timeout reports that the error is a timeout
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Is reports that the operation ran into its time limit, so it isn't retried
+func (e *TimeoutError) Is(target error) bool {
+	return target == retry.ErrTimeLimit
+}
+
+// TimedOut reports whether the deadline of a client returned by WithTimeout has passed
+func (c *Client) TimedOut() bool {
+	return c.timeout > 0 && errors.Is(c.ctx.Err(), context.DeadlineExceeded)
+}
+
+// TimeoutErr returns a "timed out" error in place of err if the client's deadline has
+// passed
+func (c *Client) TimeoutErr(err error) error {
+	if err != nil && c.TimedOut() {
+		return &TimeoutError{Limit: c.timeout}
 	}
-	
-	// Cache the result
-	c.containerCache[containerID] = &containerJSON
-	return &containerJSON, nil
+	return err
+}
+
+// Close closes the Docker client connection
+func (c *Client) Close() error {
+	return c.cli.Close()
 }
 
 // GetCurrentImageId inspects a container and returns its current image ID
@@ -139,8 +255,8 @@ func (c *Client) GetImageId(imageName string) (string, error) {
 		return "", err
 	}
 
-	// Look up image in cache
-	if image, exists := c.imageCache[imageName]; exists {
+	image, exists := c.cachedImage(imageName)
+	if exists {
 		// Remove sha256: prefix if present
 		imageID := image.ID
 		if strings.HasPrefix(imageID, "sha256:") {
@@ -153,12 +269,248 @@ func (c *Client) GetImageId(imageName string) (string, error) {
 	return "", nil
 }
 
-// RefreshImageCache clears and repopulates the image cache
-// This should be called after docker-compose pull operations
-func (c *Client) RefreshImageCache() error {
-	// Clear existing cache
-	c.imageCache = make(map[string]*types.ImageSummary)
-	
-	// Repopulate cache
-	return c.populateImageCache()
-}
\ No newline at end of file
+// OCI image annotation labels describing where an image came from
+const (
+	LabelVersion  = "org.opencontainers.image.version"
+	LabelRevision = "org.opencontainers.image.revision"
+	LabelCreated  = "org.opencontainers.image.created"
+	LabelSource   = "org.opencontainers.image.source"
+)
+
+// ImageInfo holds the identifying metadata of an image
+type ImageInfo struct {
+	ID          string
+	Version     string
+	Revision    string
+	Created     string
+	Source      string
+	RepoDigests []string  // registry references of the image, e.g. "nginx@sha256:..."
+	CreatedAt   time.Time // when the image was built, zero if unknown
+}
+
+// ShortID returns the image ID truncated to 12 characters
+func (i *ImageInfo) ShortID() string {
+	if len(i.ID) > 12 {
+		return i.ID[:12]
+	}
+	return i.ID
+}
+
+// ShortRevision returns the revision truncated like a short git commit hash
+func (i *ImageInfo) ShortRevision() string {
+	if len(i.Revision) > 7 {
+		return i.Revision[:7]
+	}
+	return i.Revision
+}
+
+// DisplayVersion returns the image version label, falling back to the short image ID
+func (i *ImageInfo) DisplayVersion() string {
+	if i.Version != "" {
+		return i.Version
+	}
+	return i.ShortID()
+}
+
+// GetImageInfo inspects an image and reads its OCI version, revision, created and source labels
+func (c *Client) GetImageInfo(imageID string) (*ImageInfo, error) {
+	inspect, _, err := c.cli.ImageInspectWithRaw(c.ctx, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", imageID, err)
+	}
+
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+
+	info := &ImageInfo{
+		ID:          strings.TrimPrefix(inspect.ID, "sha256:"),
+		Version:     labels[LabelVersion],
+		Revision:    labels[LabelRevision],
+		Created:     labels[LabelCreated],
+		Source:      labels[LabelSource],
+		RepoDigests: inspect.RepoDigests,
+	}
+	if info.Created == "" {
+		info.Created = inspect.Created
+	}
+
+	// Reproducible builds often set the creation time of the image config to the epoch,
+	// the OCI label is more accurate for them
+	for _, created := range []string{inspect.Created, labels[LabelCreated]} {
+		if t, err := time.Parse(time.RFC3339Nano, created); err == nil && t.Unix() > 0 {
+			info.CreatedAt = t
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// NormalizeImageRef returns the short form docker lists an image reference by, with the
+// default tag added, e.g. "docker.io/library/node" becomes "node:latest". References that
+// can't be parsed are returned unchanged.
+func NormalizeImageRef(imageRef string) string {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return imageRef
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// RepositoryName strips the tag and digest from an image reference,
+// e.g. "ghcr.io/org/app:1.2@sha256:..." becomes "ghcr.io/org/app"
+func RepositoryName(imageRef string) string {
+	if i := strings.Index(imageRef, "@"); i >= 0 {
+		imageRef = imageRef[:i]
+	}
+	// A colon after the last slash separates the tag, one before it belongs to a registry port
+	if i := strings.LastIndex(imageRef, ":"); i > strings.LastIndex(imageRef, "/") {
+		imageRef = imageRef[:i]
+	}
+	return imageRef
+}
+
+// ImagesInUse returns the IDs of all images used by any container, running or not
+func (c *Client) ImagesInUse() (map[string]bool, error) {
+	containers, err := c.cli.ContainerList(c.ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	inUse := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		inUse[strings.TrimPrefix(container.ImageID, "sha256:")] = true
+	}
+	return inUse, nil
+}
+
+// RepositoryImages lists the images that belong to a repository, including untagged
+// images that are only referenced by digest, newest first
+func (c *Client) RepositoryImages(repository string) ([]types.ImageSummary, error) {
+	images, err := c.cli.ImageList(c.ctx, types.ImageListOptions{All: false})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Docker images: %w", err)
+	}
+
+	var matches []types.ImageSummary
+	for _, image := range images {
+		refs := append(append([]string{}, image.RepoTags...), image.RepoDigests...)
+		for _, ref := range refs {
+			if ref != "<none>:<none>" && ref != "<none>@<none>" && RepositoryName(ref) == repository {
+				matches = append(matches, image)
+				break
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Created > matches[j].Created
+	})
+	return matches, nil
+}
+
+// RemoveImage deletes an image and drops it from the image cache
+func (c *Client) RemoveImage(imageID string) error {
+	if _, err := c.cli.ImageRemove(c.ctx, imageID, types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+		return fmt.Errorf("failed to remove image %s: %w", imageID, err)
+	}
+
+	c.forgetImage(imageID)
+	return nil
+}
+
+// TagImage points a reference, e.g. "postgres:16", at an image and refreshes the image
+// cache for it
+func (c *Client) TagImage(imageID string, imageRef string) error {
+	if err := c.cli.ImageTag(c.ctx, imageID, imageRef); err != nil {
+		return fmt.Errorf("failed to tag image %s as %s: %w", imageID, imageRef, err)
+	}
+	return c.RefreshImages([]string{imageRef})
+}
+
+// ContainerStatus describes the state of a single container of a service
+type ContainerStatus struct {
+	ID      string
+	Name    string
+	ImageID string
+	State   string // e.g. running, exited, restarting
+	Health  string // healthy, unhealthy, starting, or empty without a healthcheck
+}
+
+// IsRunning reports whether the container is running
+func (s *ContainerStatus) IsRunning() bool {
+	return s.State == "running"
+}
+
+// String formats the status, e.g. "app-web-1 running (healthy)"
+func (s *ContainerStatus) String() string {
+	if s.Health != "" {
+		return fmt.Sprintf("%s %s (%s)", s.Name, s.State, s.Health)
+	}
+	return fmt.Sprintf("%s %s", s.Name, s.State)
+}
+
+// GetContainerStatus inspects a container, bypassing the cache, and returns its current status
+func (c *Client) GetContainerStatus(containerID string) (*ContainerStatus, error) {
+	containerJSON, err := c.cli.ContainerInspect(c.ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, err)
+	}
+
+	status := &ContainerStatus{
+		ID:      containerJSON.ID,
+		Name:    strings.TrimPrefix(containerJSON.Name, "/"),
+		ImageID: strings.TrimPrefix(containerJSON.Image, "sha256:"),
+	}
+	if containerJSON.State != nil {
+		status.State = containerJSON.State.Status
+		if containerJSON.State.Health != nil {
+			status.Health = containerJSON.State.Health.Status
+		}
+	}
+	return status, nil
+}
+
+// StopContainer stops a container
+func (c *Client) StopContainer(containerID string) error {
+	if err := c.cli.ContainerStop(c.ctx, containerID, nil); err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", containerID, err)
+	}
+	return nil
+}
+
+// RemoveContainer removes a stopped container and drops it from the container cache
+func (c *Client) RemoveContainer(containerID string) error {
+	if err := c.cli.ContainerRemove(c.ctx, containerID, types.ContainerRemoveOptions{}); err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", containerID, err)
+	}
+	c.forgetContainer(containerID)
+	return nil
+}
+
+// WaitHealthy polls a container until it is healthy, or running if it has no healthcheck
+func (c *Client) WaitHealthy(containerID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := c.GetContainerStatus(containerID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status.Health == "healthy", status.IsRunning() && status.Health == "":
+			return nil
+		case status.Health == "unhealthy":
+			return fmt.Errorf("container %s is unhealthy", status.Name)
+		case status.State == "exited" || status.State == "dead":
+			return fmt.Errorf("container %s %s", status.Name, status.State)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("container %s did not become healthy within %s", status.Name, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
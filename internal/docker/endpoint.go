@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// defaultContext is the docker context that stands for DOCKER_HOST or the local socket
+const defaultContext = "default"
+
+// endpoint is the daemon a client connects to
+type endpoint struct {
+	Host    string      // e.g. "unix:///var/run/docker.sock" or "ssh://user@host"
+	Context string      // docker context the host came from, if any
+	TLS     *tls.Config // TLS settings from the docker context, if any
+}
+
+// contextMeta is the part of a docker context's meta.json dc-update needs
+type contextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// resolveEndpoint picks the daemon the same way the docker CLI does, so compose commands
+// and the API client agree: --host, then --context, then DOCKER_HOST, then DOCKER_CONTEXT,
+// then the current context from the docker CLI config, then the local socket
+func resolveEndpoint(config Config, engine string) (endpoint, error) {
+	if config.Host != "" && config.Context != "" {
+		return endpoint{}, fmt.Errorf("--host and --context cannot be used together")
+	}
+	if config.Host != "" {
+		return endpoint{Host: config.Host}, nil
+	}
+
+	if engine == EnginePodman {
+		if config.Context != "" {
+			return endpoint{}, fmt.Errorf("docker contexts cannot be used with Podman, use --host instead")
+		}
+		host, err := podmanHost()
+		if err != nil {
+			return endpoint{}, err
+		}
+		return endpoint{Host: host}, nil
+	}
+
+	contextName := config.Context
+	if contextName == "" {
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			return endpoint{Host: host}, nil
+		}
+		contextName = os.Getenv("DOCKER_CONTEXT")
+	}
+	if contextName == "" {
+		contextName = currentContext()
+	}
+
+	if contextName == "" || contextName == defaultContext {
+		return endpoint{Host: client.DefaultDockerHost}, nil
+	}
+	return loadContext(contextName)
+}
+
+// dockerConfigDir returns the docker CLI configuration directory
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the context selected with `docker context use`, if any
+func currentContext() string {
+	data, err := os.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ""
+	}
+	return config.CurrentContext
+}
+
+// loadContext reads a docker context from the docker CLI's context store
+func loadContext(name string) (endpoint, error) {
+	// Contexts are stored in directories named after the SHA-256 of the context name
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(dockerConfigDir(), "contexts", "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return endpoint{}, fmt.Errorf("docker context '%s' does not exist", name)
+	}
+	if err != nil {
+		return endpoint{}, fmt.Errorf("failed to read docker context '%s': %w", name, err)
+	}
+
+	var meta contextMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return endpoint{}, fmt.Errorf("failed to parse docker context '%s': %w", name, err)
+	}
+	dockerEndpoint, ok := meta.Endpoints["docker"]
+	if !ok || dockerEndpoint.Host == "" {
+		return endpoint{}, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+
+	ep := endpoint{Host: dockerEndpoint.Host, Context: name}
+
+	// TLS material, if any, is stored next to the metadata as ca.pem, cert.pem and key.pem
+	tlsDir := filepath.Join(dockerConfigDir(), "contexts", "tls", id, "docker")
+	options := tlsconfig.Options{InsecureSkipVerify: dockerEndpoint.SkipTLSVerify}
+	if path := filepath.Join(tlsDir, "ca.pem"); fileExists(path) {
+		options.CAFile = path
+		options.ExclusiveRootPools = true
+	}
+	if path := filepath.Join(tlsDir, "cert.pem"); fileExists(path) {
+		options.CertFile = path
+		options.KeyFile = filepath.Join(tlsDir, "key.pem")
+	}
+	if options.CAFile != "" || options.CertFile != "" || options.InsecureSkipVerify {
+		ep.TLS, err = tlsconfig.Client(options)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("failed to load TLS settings of docker context '%s': %w", name, err)
+		}
+	}
+
+	return ep, nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Env returns the environment that points docker and compose commands at the same
+// daemon as the client
+func (ep endpoint) Env() []string {
+	if ep.Context != "" {
+		// DOCKER_HOST would take precedence over the context and drop its TLS settings,
+		// so an inherited one is cleared
+		return []string{"DOCKER_CONTEXT=" + ep.Context, "DOCKER_HOST="}
+	}
+	return []string{"DOCKER_CONTEXT=", "DOCKER_HOST=" + ep.Host}
+}
@@ -0,0 +1,72 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Supported container engines
+const (
+	EngineAuto   = "auto"
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+)
+
+// dockerSocket is the default Docker daemon socket
+const dockerSocket = "/var/run/docker.sock"
+
+// podmanSockets returns the Podman API sockets to try, rootless first
+func podmanSockets() []string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return []string{
+		filepath.Join(runtimeDir, "podman", "podman.sock"),
+		"/run/podman/podman.sock",
+	}
+}
+
+// podmanHost returns the address of the Podman API: CONTAINER_HOST if it points at
+// a unix socket, otherwise the first Podman socket that exists
+func podmanHost() (string, error) {
+	if host := os.Getenv("CONTAINER_HOST"); strings.HasPrefix(host, "unix://") {
+		return host, nil
+	}
+	for _, socket := range podmanSockets() {
+		if socketExists(socket) {
+			return "unix://" + socket, nil
+		}
+	}
+	return "", fmt.Errorf("no Podman API socket found in %s - enable it with `systemctl --user enable --now podman.socket` (rootless) or `systemctl enable --now podman.socket` (rootful)",
+		strings.Join(podmanSockets(), ", "))
+}
+
+// resolveEngine turns EngineAuto into EngineDocker or EnginePodman. An explicit host or
+// context, DOCKER_HOST, DOCKER_CONTEXT or a Docker socket selects Docker, otherwise a
+// Podman socket selects Podman.
+func resolveEngine(config Config) (string, error) {
+	switch config.Engine {
+	case EngineDocker, EnginePodman:
+		return config.Engine, nil
+	case "", EngineAuto:
+		if config.Host != "" || config.Context != "" || os.Getenv("DOCKER_HOST") != "" ||
+			os.Getenv("DOCKER_CONTEXT") != "" || socketExists(dockerSocket) {
+			return EngineDocker, nil
+		}
+		if _, err := podmanHost(); err == nil {
+			return EnginePodman, nil
+		}
+		return EngineDocker, nil
+	default:
+		return "", fmt.Errorf("unknown container engine '%s' (expected auto, docker or podman)", config.Engine)
+	}
+}
+
+// socketExists reports whether path is a unix socket
+func socketExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
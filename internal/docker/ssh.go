@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"dc-update/internal/interrupt"
+)
+
+// sshDialer returns a dialer that reaches the remote daemon of an ssh:// host by running
+// `ssh host <engine> system dial-stdio`, like the docker CLI does. Authentication is left
+// to ssh, so keys, agents and ~/.ssh/config all work as usual. Cancelling ctx ends the
+// connections dialed before.
+func sshDialer(ctx context.Context, host string, engine string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh host '%s': %w", host, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ssh host '%s': no hostname", host)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("invalid ssh host '%s': paths are not supported", host)
+	}
+
+	var args []string
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	args = append(args, "--", u.Hostname(), engine, "system", "dial-stdio")
+
+	return func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		if err := dialCtx.Err(); err != nil {
+			return nil, err
+		}
+		cmd := exec.Command("ssh", args...)
+		// The connection must outlive the first Ctrl-C so running updates can finish, it
+		// ends with ctx instead. Requests cancelled on their own close the connection.
+		interrupt.Separate(cmd)
+		conn, err := newCommandConn(cmd)
+		if err != nil {
+			return nil, err
+		}
+		// Connections dialed after ctx is done serve detached clients and stay open
+		if ctx.Err() == nil {
+			conn.stopAfter = context.AfterFunc(ctx, func() { conn.Close() })
+		}
+		return conn, nil
+	}, nil
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *lockedBuffer
+
+	stopAfter func() bool // stops closing the connection once the dialer's context is done
+	closeOnce sync.Once
+}
+
+// newCommandConn starts the command and connects to its stdin and stdout
+func newCommandConn(cmd *exec.Cmd) (*commandConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &lockedBuffer{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", strings.Join(cmd.Args, " "), err)
+	}
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+// Read reads from the command's stdout. If the command exits after writing to stderr,
+// e.g. because ssh authentication failed, that output is returned as the error.
+func (c *commandConn) Read(p []byte) (int, error) {
+	n, err := c.stdout.Read(p)
+	if err == io.EOF {
+		if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+			return n, fmt.Errorf("connection closed by %s: %s", c.cmd.Args[0], msg)
+		}
+	}
+	return n, err
+}
+
+// Write writes to the command's stdin
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close ends the command
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.stopAfter != nil {
+			c.stopAfter()
+		}
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// commandAddr is the placeholder address of a commandConn
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
+
+// lockedBuffer is a bytes.Buffer that is safe to write from the exec goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
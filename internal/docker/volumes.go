@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// LabelHelper marks the throwaway containers dc-update runs to archive volumes
+const LabelHelper = "dc-update.helper"
+
+// helperMountPoint is where helper containers mount the volume they work on
+const helperMountPoint = "/volume"
+
+// ContainerVolumes returns the names of the volumes mounted into a container. Bind
+// mounts and tmpfs mounts are not included.
+func (c *Client) ContainerVolumes(containerID string) ([]string, error) {
+	containerJSON, err := c.getContainerInspection(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []string
+	for _, m := range containerJSON.Mounts {
+		if m.Type == mount.TypeVolume && m.Name != "" {
+			volumes = append(volumes, m.Name)
+		}
+	}
+	return volumes, nil
+}
+
+// EnsureImage pulls an image unless it is already present
+func (c *Client) EnsureImage(imageRef string) error {
+	if _, _, err := c.cli.ImageInspectWithRaw(c.ctx, imageRef); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect image %s: %w", imageRef, err)
+	}
+	return c.PullImage(imageRef)
+}
+
+// ExportVolume writes a gzipped tar archive of a volume's contents to w. The archive is
+// made by a throwaway container of helperImage with the volume mounted read-only, and
+// streamed back over the API so it also works with remote daemons.
+func (c *Client) ExportVolume(volume string, helperImage string, w io.Writer) error {
+	cmd := []string{"tar", "czf", "-", "-C", helperMountPoint, "."}
+	if err := c.runHelper(volume, true, helperImage, cmd, nil, w); err != nil {
+		return fmt.Errorf("failed to archive volume %s: %w", volume, err)
+	}
+	return nil
+}
+
+// ImportVolume replaces the contents of a volume with a gzipped tar archive read from r,
+// using a throwaway container of helperImage. The volume is created if it doesn't exist.
+// The archive is received and checked completely before the volume is emptied, so a
+// truncated or corrupt archive leaves the volume alone.
+func (c *Client) ImportVolume(volume string, helperImage string, r io.Reader) error {
+	script := fmt.Sprintf("cat > /tmp/archive.tar.gz && tar tzf /tmp/archive.tar.gz > /dev/null && "+
+		"find %[1]s -mindepth 1 -delete && tar xzf /tmp/archive.tar.gz -C %[1]s", helperMountPoint)
+	if err := c.runHelper(volume, false, helperImage, []string{"sh", "-c", script}, r, io.Discard); err != nil {
+		return fmt.Errorf("failed to restore volume %s: %w", volume, err)
+	}
+	return nil
+}
+
+// runHelper runs a command in a throwaway container with a volume mounted, feeding it
+// stdin if set and copying its standard output to stdout
+func (c *Client) runHelper(volume string, readOnly bool, image string, cmd []string, stdin io.Reader, stdout io.Writer) error {
+	if err := c.EnsureImage(image); err != nil {
+		return err
+	}
+
+	config := &container.Config{
+		Image:        image,
+		Cmd:          cmd,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    stdin != nil,
+		StdinOnce:    stdin != nil,
+		Labels:       map[string]string{LabelHelper: volume},
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode: "none",
+		Mounts: []mount.Mount{{
+			Type:     mount.TypeVolume,
+			Source:   volume,
+			Target:   helperMountPoint,
+			ReadOnly: readOnly,
+		}},
+	}
+
+	created, err := c.cli.ContainerCreate(c.ctx, config, hostConfig, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create helper container: %w", err)
+	}
+	defer c.cli.ContainerRemove(c.ctx, created.ID, types.ContainerRemoveOptions{Force: true})
+
+	attach, err := c.cli.ContainerAttach(c.ctx, created.ID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to helper container: %w", err)
+	}
+	defer attach.Close()
+
+	// Wait for the next exit before starting, so a quick exit isn't missed
+	waitCh, waitErrCh := c.cli.ContainerWait(c.ctx, created.ID, container.WaitConditionNextExit)
+
+	if err := c.cli.ContainerStart(c.ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start helper container: %w", err)
+	}
+
+	stdinErr := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(attach.Conn, stdin)
+			attach.CloseWrite()
+			stdinErr <- err
+		}()
+	} else {
+		stdinErr <- nil
+	}
+
+	var stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(stdout, &stderr, attach.Reader); err != nil {
+		return fmt.Errorf("failed to read helper output: %w", err)
+	}
+
+	select {
+	case err := <-waitErrCh:
+		return fmt.Errorf("failed to wait for helper container: %w", err)
+	case status := <-waitCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("helper exited with code %d: %s", status.StatusCode, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	if err := <-stdinErr; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return fmt.Errorf("failed to send archive to helper: %w", err)
+	}
+	return nil
+}
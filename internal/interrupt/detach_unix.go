@@ -0,0 +1,28 @@
+//go:build !windows
+
+package interrupt
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Detach starts a command in its own process group, so a Ctrl-C in the terminal only
+// reaches dc-update, which decides when to cancel the command. Cancelling the command
+// kills the whole group, e.g. also the compose plugin started by `docker compose`. The
+// command must be created with exec.CommandContext.
+func Detach(cmd *exec.Cmd) {
+	Separate(cmd)
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// Separate starts a command in its own process group, like Detach, for commands that
+// aren't created with a context and are ended by their caller
+func Separate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package interrupt
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Detach starts a command in its own process group, so a Ctrl-C in the console only
+// reaches dc-update, which decides when to cancel the command
+func Detach(cmd *exec.Cmd) {
+	Separate(cmd)
+}
+
+// Separate starts a command in its own process group, like Detach, for commands that
+// aren't created with a context and are ended by their caller
+func Separate(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
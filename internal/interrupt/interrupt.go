@@ -0,0 +1,48 @@
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Contexts returns two contexts for a run that can be interrupted with SIGINT or SIGTERM.
+// stop is cancelled by the first signal, after which no new work should be started, while
+// work in progress is left to finish. abort is cancelled by the second signal and cancels
+// the work in progress. release stops listening for signals.
+func Contexts(parent context.Context) (stop context.Context, abort context.Context, release func()) {
+	stop, cancelStop := context.WithCancel(parent)
+	abort, cancelAbort := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, finishing the services being updated. Interrupt again to abort them.")
+		cancelStop()
+
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nAborting")
+		cancelAbort()
+	}()
+
+	release = func() {
+		signal.Stop(signals)
+		close(done)
+		cancelStop()
+		cancelAbort()
+	}
+	return stop, abort, release
+}
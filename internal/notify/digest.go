@@ -0,0 +1,214 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"dc-update/internal/report"
+)
+
+// digest is the data passed to the digest templates
+type digest struct {
+	Hostname    string
+	Project     string
+	Headline    string
+	Duration    string
+	Changes     []digestLine
+	Failures    []digestLine
+	Pending     []digestLine
+	Deferred    []digestLine
+	Interrupted []digestLine
+}
+
+// digestLine is a single service entry in a digest
+type digestLine struct {
+	Service    string
+	Detail     string
+	Created    string
+	Source     string
+	OldCreated string // provenance of the replaced image, if it differs
+	OldSource  string
+	Replicas   []string
+	Hooks      []digestHook
+	Backup     string
+	Attempts   int // pull attempts, shown if more than one
+}
+
+// digestHook is a hook run for a service, with its output if it failed
+type digestHook struct {
+	Summary string
+	Output  string
+}
+
+// hookLines lists the hooks run for a service
+func hookLines(result report.ServiceResult) []digestHook {
+	hooks := make([]digestHook, 0, len(result.Hooks))
+	for _, hook := range result.Hooks {
+		line := digestHook{Summary: hook.String()}
+		if hook.Err != nil {
+			line.Output = hook.Output
+		}
+		hooks = append(hooks, line)
+	}
+	return hooks
+}
+
+// heldLines lists services whose update was held back, with the reason
+func heldLines(results []report.ServiceResult) []digestLine {
+	var lines []digestLine
+	for _, result := range results {
+		detail := result.Reason
+		if change := result.VersionChange(); change != "" {
+			detail = change + ", " + detail
+		}
+		lines = append(lines, digestLine{Service: result.Service, Detail: detail})
+	}
+	return lines
+}
+
+// replicaLines lists per-replica status for services with more than one container
+func replicaLines(result report.ServiceResult) []string {
+	if len(result.Replicas) <= 1 {
+		return nil
+	}
+	lines := make([]string, 0, len(result.Replicas))
+	for _, replica := range result.Replicas {
+		lines = append(lines, replica.String())
+	}
+	return lines
+}
+
+const textDigestTemplate = `dc-update run for {{.Project}} on {{.Hostname}}: {{.Headline}} ({{.Duration}})
+{{if .Changes}}
+Updated:
+{{range .Changes}}  - {{.Service}}{{if .Detail}}: {{.Detail}}{{end}}
+{{if .Created}}    created: {{.Created}}{{if .OldCreated}} (was {{.OldCreated}}){{end}}
+{{end}}{{if .Source}}    source: {{.Source}}{{if .OldSource}} (was {{.OldSource}}){{end}}
+{{end}}{{if .Backup}}    volumes backed up as: {{.Backup}}
+{{end}}{{if gt .Attempts 1}}    pulled after {{.Attempts}} attempts
+{{end}}{{range .Replicas}}    replica {{.}}
+{{end}}{{range .Hooks}}    hook {{.Summary}}
+{{if .Output}}{{.Output}}
+{{end}}{{end}}{{end}}{{end}}{{if .Failures}}
+Failed:
+{{range .Failures}}  - {{.Service}}{{if .Detail}}: {{.Detail}}{{end}}
+{{if .Backup}}    volumes backed up as: {{.Backup}}
+{{end}}{{range .Replicas}}    replica {{.}}
+{{end}}{{range .Hooks}}    hook {{.Summary}}
+{{if .Output}}{{.Output}}
+{{end}}{{end}}{{end}}{{end}}{{if .Pending}}
+Pending:
+{{range .Pending}}  - {{.Service}}{{if .Detail}}: {{.Detail}}{{end}}
+{{end}}{{end}}{{if .Deferred}}
+Deferred:
+{{range .Deferred}}  - {{.Service}}{{if .Detail}}: {{.Detail}}{{end}}
+{{end}}{{end}}{{if .Interrupted}}
+Interrupted:
+{{range .Interrupted}}  - {{.Service}}{{if .Detail}}: {{.Detail}}{{end}}
+{{end}}{{end}}`
+
+const htmlDigestTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>dc-update run for <strong>{{.Project}}</strong> on <strong>{{.Hostname}}</strong>: {{.Headline}} ({{.Duration}})</p>
+{{if .Changes}}<h3>Updated</h3>
+<ul>
+{{range .Changes}}<li><strong>{{.Service}}</strong>{{if .Detail}}: {{.Detail}}{{end}}{{if .Created}}<br><small>created {{.Created}}{{if .OldCreated}} (was {{.OldCreated}}){{end}}</small>{{end}}{{if .Source}}<br><small><a href="{{.Source}}">{{.Source}}</a>{{if .OldSource}} (was <a href="{{.OldSource}}">{{.OldSource}}</a>){{end}}</small>{{end}}{{if .Backup}}<br><small>volumes backed up as {{.Backup}}</small>{{end}}{{if gt .Attempts 1}}<br><small>pulled after {{.Attempts}} attempts</small>{{end}}{{range .Replicas}}<br><small>replica {{.}}</small>{{end}}{{range .Hooks}}<br><small>hook {{.Summary}}</small>{{if .Output}}<pre>{{.Output}}</pre>{{end}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Failures}}<h3>Failed</h3>
+<ul>
+{{range .Failures}}<li><strong>{{.Service}}</strong>{{if .Detail}}: <code>{{.Detail}}</code>{{end}}{{if .Backup}}<br><small>volumes backed up as {{.Backup}}</small>{{end}}{{range .Replicas}}<br><small>replica {{.}}</small>{{end}}{{range .Hooks}}<br><small>hook {{.Summary}}</small>{{if .Output}}<pre>{{.Output}}</pre>{{end}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Pending}}<h3>Pending</h3>
+<ul>
+{{range .Pending}}<li><strong>{{.Service}}</strong>{{if .Detail}}: {{.Detail}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Deferred}}<h3>Deferred</h3>
+<ul>
+{{range .Deferred}}<li><strong>{{.Service}}</strong>{{if .Detail}}: {{.Detail}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Interrupted}}<h3>Interrupted</h3>
+<ul>
+{{range .Interrupted}}<li><strong>{{.Service}}</strong>{{if .Detail}}: {{.Detail}}{{end}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`
+
+var (
+	textDigest = texttemplate.Must(texttemplate.New("text").Parse(textDigestTemplate))
+	htmlDigest = htmltemplate.Must(htmltemplate.New("html").Parse(htmlDigestTemplate))
+)
+
+// newDigest builds the template data for a report
+func newDigest(rep *report.Report) digest {
+	d := digest{
+		Hostname: rep.Hostname,
+		Project:  rep.Project,
+		Headline: rep.Headline(),
+		Duration: rep.FinishedAt.Sub(rep.StartedAt).Round(time.Second).String(),
+	}
+
+	for _, result := range rep.Changes() {
+		line := digestLine{Service: result.Service, Detail: result.Image}
+		if change := result.VersionChange(); change != "" {
+			line.Detail = change
+		}
+		if result.NewImage != nil {
+			line.Created = result.NewImage.Created
+			line.Source = result.NewImage.Source
+		}
+		if old := result.OldImage; old != nil {
+			if old.Created != line.Created {
+				line.OldCreated = old.Created
+			}
+			if old.Source != line.Source {
+				line.OldSource = old.Source
+			}
+		}
+		line.Replicas = replicaLines(result)
+		line.Hooks = hookLines(result)
+		line.Backup = result.Backup
+		line.Attempts = result.PullAttempts
+		d.Changes = append(d.Changes, line)
+	}
+	for _, result := range rep.Failures() {
+		detail := ""
+		if result.Err != nil {
+			detail = result.Err.Error()
+		}
+		d.Failures = append(d.Failures, digestLine{Service: result.Service, Detail: detail, Replicas: replicaLines(result), Hooks: hookLines(result), Backup: result.Backup})
+	}
+	d.Pending = heldLines(rep.Pending())
+	d.Deferred = heldLines(rep.Deferred())
+	d.Interrupted = heldLines(rep.Interrupted())
+
+	return d
+}
+
+// subject returns the subject line used for a report
+func subject(rep *report.Report) string {
+	return fmt.Sprintf("[dc-update] %s/%s: %s", rep.Hostname, rep.Project, rep.Headline())
+}
+
+// renderText renders the plain text digest of a report
+func renderText(rep *report.Report) (string, error) {
+	var buf bytes.Buffer
+	if err := textDigest.Execute(&buf, newDigest(rep)); err != nil {
+		return "", fmt.Errorf("failed to render text digest: %w", err)
+	}
+	return strings.TrimRight(buf.String(), "\n") + "\n", nil
+}
+
+// renderHTML renders the HTML digest of a report
+func renderHTML(rep *report.Report) (string, error) {
+	var buf bytes.Buffer
+	if err := htmlDigest.Execute(&buf, newDigest(rep)); err != nil {
+		return "", fmt.Errorf("failed to render HTML digest: %w", err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,340 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"dc-update/internal/docker"
+	"dc-update/internal/report"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	mqttTimeout        = 10 * time.Second
+	mqttPayloadInstall = "install"
+	mqttOnline         = "online"
+	mqttOffline        = "offline"
+)
+
+// invalidObjectIDChars matches characters Home Assistant does not allow in discovery IDs
+var invalidObjectIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// MQTTConfig holds the settings for the MQTT notifier
+type MQTTConfig struct {
+	Broker          string // e.g. tcp://localhost:1883
+	Username        string
+	Password        string
+	ClientID        string
+	DiscoveryPrefix string // Home Assistant discovery prefix, usually "homeassistant"
+	TopicPrefix     string // prefix for state and command topics
+	Hostname        string
+	Project         string
+	Version         string // dc-update version reported in the device info
+	Listen          bool   // keeps running for install requests, so it goes offline on exit
+}
+
+// MQTTNotifier publishes per-service update state to an MQTT broker, using Home
+// Assistant MQTT discovery so every service shows up as an update entity
+type MQTTNotifier struct {
+	config MQTTConfig
+	client mqtt.Client
+	nodeID string
+
+	mu         sync.Mutex
+	discovered map[string]bool
+	connects   int                  // connections made, the first one is set up by NewMQTTNotifier
+	install    func(service string) // handler of install requests, subscribed again on reconnect
+}
+
+// mqttDevice is the Home Assistant device all update entities of a project belong to
+type mqttDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// mqttDiscovery is the Home Assistant discovery payload for an update entity
+type mqttDiscovery struct {
+	Name              string     `json:"name"`
+	UniqueID          string     `json:"unique_id"`
+	StateTopic        string     `json:"state_topic"`
+	CommandTopic      string     `json:"command_topic"`
+	PayloadInstall    string     `json:"payload_install"`
+	AvailabilityTopic string     `json:"availability_topic"`
+	Device            mqttDevice `json:"device"`
+}
+
+// mqttState is the JSON state payload of an update entity
+type mqttState struct {
+	InstalledVersion string `json:"installed_version"`
+	LatestVersion    string `json:"latest_version"`
+	Title            string `json:"title,omitempty"`
+	ReleaseSummary   string `json:"release_summary,omitempty"`
+	ReleaseURL       string `json:"release_url,omitempty"`
+	InProgress       bool   `json:"in_progress"`
+}
+
+// NewMQTTNotifier validates the configuration and connects to the broker
+func NewMQTTNotifier(config MQTTConfig) (*MQTTNotifier, error) {
+	if config.Broker == "" {
+		return nil, fmt.Errorf("MQTT broker is required")
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = "homeassistant"
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "dc-update"
+	}
+
+	nodeID := invalidObjectIDChars.ReplaceAllString(fmt.Sprintf("%s_%s", config.Hostname, config.Project), "_")
+	if config.ClientID == "" {
+		config.ClientID = "dc-update_" + nodeID
+	}
+
+	n := &MQTTNotifier{
+		config:     config,
+		nodeID:     nodeID,
+		discovered: make(map[string]bool),
+	}
+
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(n.onConnect)
+	if config.Listen {
+		clientOpts.SetWill(n.availabilityTopic(), mqttOffline, 1, true)
+	}
+
+	n.client = mqtt.NewClient(clientOpts)
+	if err := wait(n.client.Connect()); err != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", config.Broker, err)
+	}
+
+	if err := n.publish(n.availabilityTopic(), mqttOnline); err != nil {
+		n.client.Disconnect(250)
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// onConnect marks the device online again and renews the install subscription after the
+// client reconnected, as a broker that lost the session also dropped the subscription
+// and may have published the will
+func (n *MQTTNotifier) onConnect(_ mqtt.Client) {
+	n.mu.Lock()
+	n.connects++
+	reconnected, install := n.connects > 1, n.install
+	n.mu.Unlock()
+	if !reconnected {
+		return
+	}
+
+	if err := n.publish(n.availabilityTopic(), mqttOnline); err != nil {
+		fmt.Fprintf(os.Stderr, "MQTT reconnect: %v\n", err)
+	}
+	if install != nil {
+		if err := n.subscribe(install); err != nil {
+			fmt.Fprintf(os.Stderr, "MQTT reconnect: %v\n", err)
+		}
+	}
+}
+
+// wait blocks until an MQTT operation completes or times out
+func wait(token mqtt.Token) error {
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("timed out after %s", mqttTimeout)
+	}
+	return token.Error()
+}
+
+// Name returns the notifier name
+func (n *MQTTNotifier) Name() string {
+	return "mqtt"
+}
+
+// Close disconnects from the broker. A listener marks the device offline first, as
+// nobody handles install requests anymore. After a single run the device stays online, so
+// Home Assistant keeps showing the published state until the next run.
+func (n *MQTTNotifier) Close() error {
+	var err error
+	if n.config.Listen {
+		err = n.publish(n.availabilityTopic(), mqttOffline)
+	}
+	n.client.Disconnect(250)
+	return err
+}
+
+// availabilityTopic is shared by all entities of this project
+func (n *MQTTNotifier) availabilityTopic() string {
+	return fmt.Sprintf("%s/%s/status", n.config.TopicPrefix, n.nodeID)
+}
+
+// serviceTopic returns the base topic for a service
+func (n *MQTTNotifier) serviceTopic(service string) string {
+	return fmt.Sprintf("%s/%s/%s", n.config.TopicPrefix, n.nodeID, service)
+}
+
+// publish sends a retained message
+func (n *MQTTNotifier) publish(topic string, payload interface{}) error {
+	if err := wait(n.client.Publish(topic, 1, true, payload)); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// publishJSON sends a retained JSON message
+func (n *MQTTNotifier) publishJSON(topic string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode MQTT payload for %s: %w", topic, err)
+	}
+	return n.publish(topic, data)
+}
+
+// ensureDiscovery publishes the Home Assistant discovery config for a service once
+func (n *MQTTNotifier) ensureDiscovery(service string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.discovered[service] {
+		return nil
+	}
+
+	objectID := invalidObjectIDChars.ReplaceAllString(service, "_")
+	topic := fmt.Sprintf("%s/update/%s/%s/config", n.config.DiscoveryPrefix, n.nodeID, objectID)
+	payload := mqttDiscovery{
+		Name:              service,
+		UniqueID:          fmt.Sprintf("dc-update_%s_%s", n.nodeID, objectID),
+		StateTopic:        n.serviceTopic(service) + "/state",
+		CommandTopic:      n.serviceTopic(service) + "/install",
+		PayloadInstall:    mqttPayloadInstall,
+		AvailabilityTopic: n.availabilityTopic(),
+		Device: mqttDevice{
+			Identifiers:  []string{"dc-update_" + n.nodeID},
+			Name:         fmt.Sprintf("dc-update %s/%s", n.config.Hostname, n.config.Project),
+			Manufacturer: "dc-update",
+			SWVersion:    n.config.Version,
+		},
+	}
+
+	if err := n.publishJSON(topic, payload); err != nil {
+		return err
+	}
+	n.discovered[service] = true
+	return nil
+}
+
+// imageVersion returns the version shown in Home Assistant for an image
+func imageVersion(info *docker.ImageInfo, id string) string {
+	if info != nil {
+		return info.DisplayVersion()
+	}
+	return report.ShortID(id)
+}
+
+// newState builds the update entity state for a service result
+func newState(result report.ServiceResult) mqttState {
+	state := mqttState{
+		InstalledVersion: imageVersion(result.InstalledImage(), result.InstalledImageID()),
+		LatestVersion:    imageVersion(result.NewImage, result.NewImageID),
+		Title:            result.Image,
+	}
+	if state.LatestVersion == "" {
+		state.LatestVersion = state.InstalledVersion
+	}
+
+	if latest := result.NewImage; latest != nil {
+		state.ReleaseURL = latest.Source
+		var details []string
+		if latest.Revision != "" {
+			details = append(details, "revision "+latest.ShortRevision())
+		}
+		if latest.Created != "" {
+			details = append(details, "created "+latest.Created)
+		}
+		state.ReleaseSummary = strings.Join(details, ", ")
+	}
+	if result.Reason != "" {
+		state.ReleaseSummary = "Update held back: " + result.Reason
+	}
+	if result.Err != nil {
+		state.ReleaseSummary = fmt.Sprintf("Last update failed: %v", result.Err)
+	}
+
+	return state
+}
+
+// PublishResult publishes the update state of a single service
+func (n *MQTTNotifier) PublishResult(result report.ServiceResult) error {
+	if err := n.ensureDiscovery(result.Service); err != nil {
+		return err
+	}
+
+	return n.publishJSON(n.serviceTopic(result.Service)+"/state", newState(result))
+}
+
+// PublishInProgress marks a service as being updated
+func (n *MQTTNotifier) PublishInProgress(result report.ServiceResult) error {
+	if err := n.ensureDiscovery(result.Service); err != nil {
+		return err
+	}
+
+	state := newState(result)
+	state.InProgress = true
+	return n.publishJSON(n.serviceTopic(result.Service)+"/state", state)
+}
+
+// Notify publishes the state of every service that has a running container
+func (n *MQTTNotifier) Notify(rep *report.Report) error {
+	var errs []error
+	for _, result := range rep.All() {
+		if result.InstalledImageID() == "" {
+			continue
+		}
+		if err := n.PublishResult(result); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SubscribeInstall calls handler with the service name whenever Home Assistant
+// requests an install for one of the project's services. The handler runs on the MQTT
+// client's goroutine and must not block.
+func (n *MQTTNotifier) SubscribeInstall(handler func(service string)) error {
+	n.mu.Lock()
+	n.install = handler
+	n.mu.Unlock()
+	return n.subscribe(handler)
+}
+
+// subscribe subscribes handler to the install topics of the project's services
+func (n *MQTTNotifier) subscribe(handler func(service string)) error {
+	prefix := fmt.Sprintf("%s/%s/", n.config.TopicPrefix, n.nodeID)
+	topic := prefix + "+/install"
+
+	token := n.client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		if string(msg.Payload()) != mqttPayloadInstall {
+			return
+		}
+		service := strings.TrimSuffix(strings.TrimPrefix(msg.Topic(), prefix), "/install")
+		handler(service)
+	})
+	if err := wait(token); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"dc-update/internal/report"
+)
+
+// Notifier delivers the results of a run to an external channel
+type Notifier interface {
+	Name() string
+	Notify(rep *report.Report) error
+}
+
+// Dispatch sends the report to every notifier and collects their errors
+func Dispatch(notifiers []Notifier, rep *report.Report) error {
+	var errs []error
+	for _, n := range notifiers {
+		if err := n.Notify(rep); err != nil {
+			errs = append(errs, fmt.Errorf("%s notification failed: %w", n.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases notifiers that hold open connections
+func Close(notifiers []Notifier) error {
+	var errs []error
+	for _, n := range notifiers {
+		if closer, ok := n.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close %s notifier: %w", n.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
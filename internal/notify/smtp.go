@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc-update/internal/report"
+)
+
+// SMTP transport security modes
+const (
+	SMTPSecurityNone     = "none"
+	SMTPSecurityStartTLS = "starttls"
+	SMTPSecurityTLS      = "tls"
+)
+
+// SMTPConfig holds the settings for the SMTP notifier
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	Security string // one of none, starttls or tls (implicit TLS)
+	// SkipVerify disables TLS certificate verification, e.g. for self-signed relays
+	SkipVerify bool
+}
+
+// SMTPNotifier emails a digest of a run's changes and failures
+type SMTPNotifier struct {
+	config SMTPConfig
+}
+
+// NewSMTPNotifier validates the configuration and creates an SMTP notifier
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP host is required")
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("SMTP sender address is required")
+	}
+	if len(config.To) == 0 {
+		return nil, fmt.Errorf("at least one SMTP recipient is required")
+	}
+
+	switch config.Security {
+	case "":
+		config.Security = SMTPSecurityStartTLS
+	case SMTPSecurityNone, SMTPSecurityStartTLS, SMTPSecurityTLS:
+	default:
+		return nil, fmt.Errorf("unknown SMTP security mode '%s' (expected none, starttls or tls)", config.Security)
+	}
+
+	if config.Port == 0 {
+		if config.Security == SMTPSecurityTLS {
+			config.Port = 465
+		} else {
+			config.Port = 587
+		}
+	}
+
+	return &SMTPNotifier{config: config}, nil
+}
+
+// Name returns the notifier name
+func (n *SMTPNotifier) Name() string {
+	return "smtp"
+}
+
+// Notify emails the digest if the run updated or failed anything
+func (n *SMTPNotifier) Notify(rep *report.Report) error {
+	if !rep.HasActivity() {
+		return nil
+	}
+
+	message, err := n.buildMessage(rep)
+	if err != nil {
+		return err
+	}
+
+	return n.send(message)
+}
+
+// dial connects to the SMTP server and negotiates TLS according to the configuration
+func (n *SMTPNotifier) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	tlsConfig := &tls.Config{
+		ServerName:         n.config.Host,
+		InsecureSkipVerify: n.config.SkipVerify,
+	}
+
+	if n.config.Security == SMTPSecurityTLS {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", addr, err)
+		}
+		client, err := smtp.NewClient(conn, n.config.Host)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start SMTP session with %s: %w", addr, err)
+		}
+		return client, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", addr, err)
+	}
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SMTP session with %s: %w", addr, err)
+	}
+
+	if n.config.Security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS with %s failed: %w", addr, err)
+		}
+	}
+
+	return client, nil
+}
+
+// send delivers a message to all recipients
+func (n *SMTPNotifier) send(message []byte) error {
+	client, err := n.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if n.config.Username != "" {
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %w", n.config.From, err)
+	}
+	for _, recipient := range n.config.To {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("SMTP server rejected recipient %s: %w", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP data transfer: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write SMTP message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+
+	return client.Quit()
+}
+
+// buildMessage renders a multipart/alternative message with text and HTML parts
+func (n *SMTPNotifier) buildMessage(rep *report.Report) ([]byte, error) {
+	text, err := renderText(rep)
+	if err != nil {
+		return nil, err
+	}
+	html, err := renderHTML(rep)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create message part: %w", err)
+		}
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to encode message part: %w", err)
+		}
+		qp.Close()
+	}
+	mw.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject(rep)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"dc-update/internal/docker"
+	"dc-update/internal/report"
+)
+
+// smtpSession is what the test SMTP server received in a session
+type smtpSession struct {
+	auth       string // decoded AUTH PLAIN response
+	from       string
+	recipients []string
+	data       string
+}
+
+// serveSMTP accepts a single session on listener, speaking just enough SMTP for the
+// notifier, and sends what it received to sessions
+func serveSMTP(t *testing.T, listener net.Listener, sessions chan<- smtpSession) {
+	conn, err := listener.Accept()
+	if err != nil {
+		// The listener was closed after the notifier failed, the test reports that
+		close(sessions)
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	var session smtpSession
+	reply := func(format string, args ...interface{}) {
+		if err := tp.PrintfLine(format, args...); err != nil {
+			t.Errorf("reply: %v", err)
+		}
+	}
+
+	reply("220 localhost ESMTP test")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			t.Errorf("read command: %v", err)
+			close(sessions)
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			_, response, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(response)
+			if err != nil {
+				t.Errorf("decode AUTH response: %v", err)
+			}
+			session.auth = string(decoded)
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			session.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			session.recipients = append(session.recipients, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 2.1.5 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				t.Errorf("read data: %v", err)
+			}
+			session.data = string(data)
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			sessions <- session
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func TestSMTPNotifierSendsDigest(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	sessions := make(chan smtpSession, 1)
+	go serveSMTP(t, listener, sessions)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	notifier, err := NewSMTPNotifier(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "dc-update",
+		Password: "secret",
+		From:     "dc-update@example.com",
+		To:       []string{"ops@example.com", "admin@example.com"},
+		Security: SMTPSecurityNone,
+	})
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+
+	rep := report.New("blog")
+	rep.Add(report.ServiceResult{
+		Service:    "web",
+		Status:     report.StatusUpdated,
+		Image:      "nginx:1.25",
+		OldImageID: "1a2b3c4d5e6f7a8b",
+		NewImageID: "5d6e7f8a9b0c1d2e",
+		OldImage:   &docker.ImageInfo{ID: "1a2b3c4d5e6f7a8b", Version: "1.25.3"},
+		NewImage:   &docker.ImageInfo{ID: "5d6e7f8a9b0c1d2e", Version: "1.25.4", Source: "https://github.com/nginx/nginx"},
+	})
+	rep.Finish()
+
+	if err := notifier.Notify(rep); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	session, ok := <-sessions
+	if !ok {
+		t.Fatal("SMTP server received no message")
+	}
+
+	if want := "\x00dc-update\x00secret"; session.auth != want {
+		t.Errorf("AUTH PLAIN = %q, want %q", session.auth, want)
+	}
+	if session.from != "dc-update@example.com" {
+		t.Errorf("MAIL FROM = %q, want dc-update@example.com", session.from)
+	}
+	if got := strings.Join(session.recipients, ","); got != "ops@example.com,admin@example.com" {
+		t.Errorf("RCPT TO = %q, want ops@example.com,admin@example.com", got)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(session.data))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+	decoder := new(mime.WordDecoder)
+	gotSubject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("decode subject: %v", err)
+	}
+	if want := subject(rep); gotSubject != want {
+		t.Errorf("Subject = %q, want %q", gotSubject, want)
+	}
+	if got := msg.Header.Get("To"); got != "ops@example.com, admin@example.com" {
+		t.Errorf("To = %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/alternative", msg.Header.Get("Content-Type"), err)
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		// multipart.Reader decodes quoted-printable parts itself and drops the header
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part body: %v", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(content)
+	}
+
+	text, ok := parts["text/plain"]
+	if !ok {
+		t.Fatal("message has no text/plain part")
+	}
+	for _, want := range []string{"dc-update run for blog", "web: 1.25.3 → 1.25.4", "source: https://github.com/nginx/nginx"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part does not contain %q:\n%s", want, text)
+		}
+	}
+	html, ok := parts["text/html"]
+	if !ok {
+		t.Fatal("message has no text/html part")
+	}
+	if !strings.Contains(html, `<a href="https://github.com/nginx/nginx">`) {
+		t.Errorf("HTML part does not link the image source:\n%s", html)
+	}
+}
+
+func TestSMTPNotifierSkipsQuietRuns(t *testing.T) {
+	notifier, err := NewSMTPNotifier(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     1, // nothing listens here, Notify must not connect
+		From:     "dc-update@example.com",
+		To:       []string{"ops@example.com"},
+		Security: SMTPSecurityNone,
+	})
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+
+	rep := report.New("blog")
+	rep.Add(report.ServiceResult{Service: "web", Status: report.StatusUpToDate})
+	rep.Finish()
+
+	if err := notifier.Notify(rep); err != nil {
+		t.Errorf("Notify without changes or failures = %v, want nil", err)
+	}
+}
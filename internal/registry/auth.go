@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// helperTimeout limits a single run of a credential helper
+const helperTimeout = 30 * time.Second
+
+// tokenUsername is the username credential helpers return along with an identity token
+const tokenUsername = "<token>"
+
+// Auth looks up the credentials of registries: those configured for dc-update first,
+// then the credential helper, credential store or stored login of the docker CLI
+// configuration, like the docker CLI does. It is safe for concurrent use.
+type Auth struct {
+	configured map[string]Credentials // by registry domain
+	configPath string
+
+	mu    sync.Mutex
+	found map[string]*lookupResult // looked up credentials by registry domain
+}
+
+// lookupResult is the outcome of looking up the credentials of a registry domain, done once
+type lookupResult struct {
+	once   sync.Once
+	creds  Credentials
+	err    error
+	warned sync.Once // the fallback to anonymous pulls is warned about once
+}
+
+// NewAuth returns the credential lookup with the credentials configured for dc-update by
+// registry domain, e.g. "ghcr.io", in front of those of the docker CLI configuration
+func NewAuth(configured map[string]Credentials) *Auth {
+	normalized := make(map[string]Credentials, len(configured))
+	for domain, creds := range configured {
+		normalized[normalizeDomain(domain)] = creds
+	}
+	return &Auth{
+		configured: normalized,
+		configPath: DockerConfigPath(),
+		found:      make(map[string]*lookupResult),
+	}
+}
+
+// DockerConfigPath returns the path of the docker CLI configuration, in DOCKER_CONFIG or
+// ~/.docker
+func DockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// Credentials returns the credentials of a registry domain, none if there are none. A nil
+// Auth has no credentials.
+func (a *Auth) Credentials(domain string) (Credentials, error) {
+	if a == nil {
+		return Credentials{}, nil
+	}
+	domain = normalizeDomain(domain)
+	if creds, ok := a.configured[domain]; ok {
+		return creds, nil
+	}
+
+	found := a.lookupOnce(domain)
+	return found.creds, found.err
+}
+
+// PullCredentials returns the credentials to pull from a registry domain with. If they
+// can't be looked up, e.g. because the credential helper fails, the images are pulled
+// anonymously after a warning, as public images don't need credentials.
+func (a *Auth) PullCredentials(domain string) Credentials {
+	if a == nil {
+		return Credentials{}
+	}
+	domain = normalizeDomain(domain)
+	if creds, ok := a.configured[domain]; ok {
+		return creds
+	}
+
+	found := a.lookupOnce(domain)
+	if found.err != nil {
+		found.warned.Do(func() {
+			fmt.Fprintf(os.Stderr, "Warning: pulling from %s anonymously: %v\n", domain, found.err)
+		})
+		return Credentials{}
+	}
+	return found.creds
+}
+
+// lookupOnce looks up the credentials of a registry domain the first time they are asked
+// for. The outcome is kept, errors included, so a failing credential helper isn't run for
+// every image. Lookups of different domains don't wait for each other.
+func (a *Auth) lookupOnce(domain string) *lookupResult {
+	a.mu.Lock()
+	found, ok := a.found[domain]
+	if !ok {
+		found = &lookupResult{}
+		a.found[domain] = found
+	}
+	a.mu.Unlock()
+
+	found.once.Do(func() {
+		found.creds, found.err = a.lookup(domain)
+	})
+	return found
+}
+
+// dockerConfig is the part of the docker CLI configuration holding registry credentials
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`  // helper storing all credentials, e.g. "desktop"
+	CredHelpers map[string]string     `json:"credHelpers"` // helper by registry domain
+}
+
+// dockerAuth is the entry `docker login` stores for a registry
+type dockerAuth struct {
+	Auth          string `json:"auth"` // base64 of "username:password"
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// lookup reads the credentials of a registry domain from the docker CLI configuration
+func (a *Auth) lookup(domain string) (Credentials, error) {
+	if a.configPath == "" {
+		return Credentials{}, nil
+	}
+	data, err := os.ReadFile(a.configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to read %s: %w", a.configPath, err)
+	}
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Credentials{}, fmt.Errorf("failed to parse %s: %w", a.configPath, err)
+	}
+
+	for key, helper := range cfg.CredHelpers {
+		if normalizeDomain(key) == domain {
+			return runHelper(helper, ServerAddress(domain))
+		}
+	}
+	if cfg.CredsStore != "" {
+		return runHelper(cfg.CredsStore, ServerAddress(domain))
+	}
+	for key, auth := range cfg.Auths {
+		if normalizeDomain(key) != domain {
+			continue
+		}
+		creds, err := auth.credentials()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("%s: credentials of %s: %w", a.configPath, key, err)
+		}
+		return creds, nil
+	}
+	return Credentials{}, nil
+}
+
+// credentials decodes a stored login
+func (a dockerAuth) credentials() (Credentials, error) {
+	creds := Credentials{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+	if a.Auth == "" {
+		return creds, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("invalid auth: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return Credentials{}, fmt.Errorf("invalid auth: expected username:password")
+	}
+	creds.Username, creds.Password = username, password
+	return creds, nil
+}
+
+// runHelper asks the credential helper docker-credential-<helper> for the credentials of
+// a server. Servers the helper has no credentials for have none.
+func runHelper(helper string, server string) (Credentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	program := "docker-credential-" + helper
+	cmd := exec.CommandContext(ctx, program, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers report missing credentials on stdout and exit with an error
+		message := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		if strings.Contains(strings.ToLower(message), "credentials not found") {
+			return Credentials{}, nil
+		}
+		if message != "" {
+			err = fmt.Errorf("%w: %s", err, message)
+		}
+		return Credentials{}, fmt.Errorf("credential helper %s failed for %s: %w", program, server, err)
+	}
+
+	var out struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return Credentials{}, fmt.Errorf("invalid output of credential helper %s: %w", program, err)
+	}
+	if out.Username == tokenUsername {
+		return Credentials{IdentityToken: out.Secret}, nil
+	}
+	return Credentials{Username: out.Username, Password: out.Secret}, nil
+}
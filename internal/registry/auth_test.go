@@ -0,0 +1,227 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeHelper is a credential helper answering like docker-credential-desktop: ghcr.io has
+// a login, Docker Hub an identity token, broken.example.com makes it fail and other servers
+// have no credentials. It records the servers it is asked for in a calls file next to it.
+const fakeHelper = `#!/bin/sh
+read server
+echo "$server" >> "$(dirname "$0")/calls"
+case "$server" in
+ghcr.io) echo '{"ServerURL":"ghcr.io","Username":"helper","Secret":"s3cret"}' ;;
+https://index.docker.io/v1/) echo '{"ServerURL":"https://index.docker.io/v1/","Username":"<token>","Secret":"refresh"}' ;;
+broken.example.com) echo "keychain locked" >&2; exit 1 ;;
+*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+
+// setupDockerConfig writes a docker CLI configuration and installs the fake credential
+// helper as docker-credential-fake, returning the directory of both
+func setupDockerConfig(t *testing.T, dockerConfig string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(fakeHelper), 0o755); err != nil {
+		t.Fatalf("write helper: %v", err)
+	}
+	if dockerConfig != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(dockerConfig), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// helperCalls returns the servers the fake credential helper was asked for
+func helperCalls(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("read helper calls: %v", err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestAuthCredentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		dockerConfig string
+		configured   map[string]Credentials
+		domain       string
+		want         Credentials
+		wantErr      bool
+		wantCalls    []string // servers the helper is asked for
+	}{
+		{
+			name:   "no docker config",
+			domain: "ghcr.io",
+		},
+		{
+			name:         "credential helper of the registry",
+			dockerConfig: `{"credHelpers": {"ghcr.io": "fake"}, "auths": {"ghcr.io": {"auth": "dXNlcjpwYXNz"}}}`,
+			domain:       "ghcr.io",
+			want:         Credentials{Username: "helper", Password: "s3cret"},
+			wantCalls:    []string{"ghcr.io"},
+		},
+		{
+			name:         "credential helper before the credential store",
+			dockerConfig: `{"credHelpers": {"ghcr.io": "fake"}, "credsStore": "missing"}`,
+			domain:       "ghcr.io",
+			want:         Credentials{Username: "helper", Password: "s3cret"},
+			wantCalls:    []string{"ghcr.io"},
+		},
+		{
+			name:         "credential helper of another registry",
+			dockerConfig: `{"credHelpers": {"quay.io": "missing"}, "credsStore": "fake"}`,
+			domain:       "ghcr.io",
+			want:         Credentials{Username: "helper", Password: "s3cret"},
+			wantCalls:    []string{"ghcr.io"},
+		},
+		{
+			name:         "credential store before stored logins",
+			dockerConfig: `{"credsStore": "fake", "auths": {"ghcr.io": {"auth": "dXNlcjpwYXNz"}}}`,
+			domain:       "ghcr.io",
+			want:         Credentials{Username: "helper", Password: "s3cret"},
+			wantCalls:    []string{"ghcr.io"},
+		},
+		{
+			name:         "identity token of Docker Hub",
+			dockerConfig: `{"credsStore": "fake"}`,
+			domain:       "index.docker.io",
+			want:         Credentials{IdentityToken: "refresh"},
+			wantCalls:    []string{"https://index.docker.io/v1/"},
+		},
+		{
+			name:         "credentials not found",
+			dockerConfig: `{"credsStore": "fake"}`,
+			domain:       "quay.io",
+			wantCalls:    []string{"quay.io"},
+		},
+		{
+			name:         "failing helper",
+			dockerConfig: `{"credsStore": "fake"}`,
+			domain:       "broken.example.com",
+			wantErr:      true,
+			wantCalls:    []string{"broken.example.com"},
+		},
+		{
+			name:         "missing helper",
+			dockerConfig: `{"credsStore": "missing"}`,
+			domain:       "ghcr.io",
+			wantErr:      true,
+		},
+		{
+			name:         "stored login",
+			dockerConfig: `{"auths": {"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"}, "ghcr.io": {"auth": "b3RoZXI6b3RoZXI="}}}`,
+			domain:       "docker.io",
+			want:         Credentials{Username: "user", Password: "pass"},
+		},
+		{
+			name:         "stored identity token",
+			dockerConfig: `{"auths": {"registry.example.com": {"username": "ci", "identitytoken": "refresh"}}}`,
+			domain:       "registry.example.com",
+			want:         Credentials{Username: "ci", IdentityToken: "refresh"},
+		},
+		{
+			name:         "no stored login",
+			dockerConfig: `{"auths": {"ghcr.io": {"auth": "dXNlcjpwYXNz"}}}`,
+			domain:       "quay.io",
+		},
+		{
+			name:         "invalid stored login",
+			dockerConfig: `{"auths": {"ghcr.io": {"auth": "not base64"}}}`,
+			domain:       "ghcr.io",
+			wantErr:      true,
+		},
+		{
+			name:         "invalid docker config",
+			dockerConfig: `{"auths": `,
+			domain:       "ghcr.io",
+			wantErr:      true,
+		},
+		{
+			name:         "configured credentials",
+			dockerConfig: `{"credsStore": "fake"}`,
+			configured:   map[string]Credentials{"https://broken.example.com/v2/": {Username: "bot", Password: "token"}},
+			domain:       "broken.example.com",
+			want:         Credentials{Username: "bot", Password: "token"},
+		},
+		{
+			name:         "configured credentials of another registry",
+			dockerConfig: `{"credsStore": "fake"}`,
+			configured:   map[string]Credentials{"quay.io": {Username: "bot", Password: "token"}},
+			domain:       "ghcr.io",
+			want:         Credentials{Username: "helper", Password: "s3cret"},
+			wantCalls:    []string{"ghcr.io"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupDockerConfig(t, tt.dockerConfig)
+			creds, err := NewAuth(tt.configured).Credentials(tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Credentials(%s) error = %v, want error %v", tt.domain, err, tt.wantErr)
+			}
+			if creds != tt.want {
+				t.Errorf("Credentials(%s) = %+v, want %+v", tt.domain, creds, tt.want)
+			}
+			if calls := helperCalls(t, dir); strings.Join(calls, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Errorf("helper asked for %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestAuthLooksUpOnce(t *testing.T) {
+	dir := setupDockerConfig(t, `{"credsStore": "fake"}`)
+	auth := NewAuth(nil)
+	for i := 0; i < 3; i++ {
+		if _, err := auth.Credentials("ghcr.io"); err != nil {
+			t.Fatalf("Credentials: %v", err)
+		}
+		if _, err := auth.Credentials("broken.example.com"); err == nil {
+			t.Fatal("Credentials of a failing helper: error = nil")
+		}
+	}
+	if calls := helperCalls(t, dir); len(calls) != 2 {
+		t.Errorf("helper asked for %v, want each registry once", calls)
+	}
+}
+
+func TestAuthPullCredentials(t *testing.T) {
+	setupDockerConfig(t, `{"credsStore": "fake"}`)
+	auth := NewAuth(map[string]Credentials{"quay.io": {Username: "bot", Password: "token"}})
+
+	tests := []struct {
+		domain string
+		want   Credentials
+	}{
+		{"quay.io", Credentials{Username: "bot", Password: "token"}},
+		{"ghcr.io", Credentials{Username: "helper", Password: "s3cret"}},
+		{"broken.example.com", Credentials{}}, // anonymous after a warning
+	}
+	for _, tt := range tests {
+		if got := auth.PullCredentials(tt.domain); got != tt.want {
+			t.Errorf("PullCredentials(%s) = %+v, want %+v", tt.domain, got, tt.want)
+		}
+	}
+
+	var none *Auth
+	if got := none.PullCredentials("ghcr.io"); !got.Anonymous() {
+		t.Errorf("PullCredentials of a nil Auth = %+v, want anonymous", got)
+	}
+}
@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"dc-update/internal/retry"
+)
+
+// requestTimeout limits each request to a registry
+const requestTimeout = 30 * time.Second
+
+// Client sends requests to registries, answering their Bearer and Basic authentication
+// challenges with the credentials of an Auth
+type Client struct {
+	http *http.Client
+	auth *Auth
+}
+
+// NewClient returns a client authenticating with the credentials of auth, anonymously if
+// auth is nil
+func NewClient(auth *Auth) *Client {
+	return &Client{
+		http: &http.Client{Timeout: requestTimeout},
+		auth: auth,
+	}
+}
+
+// Do sends a request without a body. If the registry answers with an authentication
+// challenge, the request is sent again with a token or the credentials of the registry.
+// Like http.Client, unsuccessful responses aren't errors.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if scheme == "" {
+		return resp, nil
+	}
+
+	creds, err := c.auth.Credentials(req.URL.Host)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	authorized := req.Clone(req.Context())
+	switch scheme {
+	case "bearer":
+		token, err := c.token(req.Context(), params, creds)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		authorized.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		if creds.Anonymous() {
+			return resp, nil
+		}
+		authorized.SetBasicAuth(creds.Username, creds.Password)
+	default:
+		return resp, nil
+	}
+	resp.Body.Close()
+	return c.http.Do(authorized)
+}
+
+// token requests a token from the realm of a Bearer challenge, for the service and scope
+// it names. Identity tokens are exchanged with the OAuth2 refresh token grant, other
+// credentials are sent with basic auth.
+func (c *Client) token(ctx context.Context, params map[string]string, creds Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm '%s' in authentication challenge", params["realm"])
+	}
+	form := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		if value := params[name]; value != "" {
+			form.Set(name, value)
+		}
+	}
+
+	var req *http.Request
+	if creds.IdentityToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", creds.IdentityToken)
+		form.Set("client_id", "dc-update")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realm.Query()
+		for name, values := range form {
+			query[name] = values
+		}
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if !creds.Anonymous() {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", retry.NewStatusError(resp)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response of %s: %w", realm.Host, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response of %s has no token", realm.Host)
+}
+
+// parseChallenge parses a WWW-Authenticate header into the lower case scheme and its
+// parameters, e.g. `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		name, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		// Quoted values may contain commas, e.g. scopes with several actions
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				params[name] = value[1:]
+				break
+			}
+			params[name] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[name], rest, _ = strings.Cut(value, ",")
+			params[name] = strings.TrimSpace(params[name])
+		}
+		rest = strings.TrimLeft(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	return strings.ToLower(scheme), params
+}
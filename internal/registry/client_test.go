@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantScheme string
+		wantParams map[string]string
+	}{
+		{"", "", map[string]string{}},
+		{`Basic realm="Registry Realm"`, "basic", map[string]string{"realm": "Registry Realm"}},
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			`bearer Realm="https://ghcr.io/token", scope="repository:org/app:pull,push"`,
+			"bearer",
+			map[string]string{"realm": "https://ghcr.io/token", "scope": "repository:org/app:pull,push"},
+		},
+		{
+			`Bearer realm=https://quay.io/v2/auth, service=quay.io`,
+			"bearer",
+			map[string]string{"realm": "https://quay.io/v2/auth", "service": "quay.io"},
+		},
+		{`Bearer realm="https://example.com/token`, "bearer", map[string]string{"realm": "https://example.com/token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			scheme, params := parseChallenge(tt.header)
+			if scheme != tt.wantScheme || !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("parseChallenge = %q, %v, want %q, %v", scheme, params, tt.wantScheme, tt.wantParams)
+			}
+		})
+	}
+}
+
+// fakeRegistry serves /v2/ behind a Bearer challenge whose token endpoint accepts the
+// login bot:secret, the identity token "refresh" and anonymous requests, and /basic/
+// behind a Basic challenge accepting bot:secret
+func fakeRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var server *httptest.Server
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse token request: %v", err)
+		}
+		if r.Form.Get("service") != "fake" || r.Form.Get("scope") != "repository:app:pull" {
+			t.Errorf("token request for service %q, scope %q", r.Form.Get("service"), r.Form.Get("scope"))
+		}
+		token := "anonymous"
+		if username, password, ok := r.BasicAuth(); ok {
+			if username != "bot" || password != "secret" {
+				http.Error(w, "invalid login", http.StatusUnauthorized)
+				return
+			}
+			token = "login"
+		}
+		if r.Method == http.MethodPost {
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+				http.Error(w, "invalid grant", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "refreshed"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"token": %q}`, token)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			fmt.Fprint(w, authorization)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:app:pull"`, server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	mux.HandleFunc("/basic/", func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); ok && username == "bot" && password == "secret" {
+			fmt.Fprint(w, "Basic bot")
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientDo(t *testing.T) {
+	// Keep the credentials of the docker CLI configuration of the host out of the test
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	server := fakeRegistry(t)
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parse server URL: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		creds      *Credentials // configured for the registry
+		wantStatus int
+		wantBody   string
+	}{
+		{"anonymous token", "/v2/app/manifests/latest", nil, http.StatusOK, "Bearer anonymous"},
+		{"token for a login", "/v2/app/manifests/latest", &Credentials{Username: "bot", Password: "secret"}, http.StatusOK, "Bearer login"},
+		{"token for an identity token", "/v2/app/manifests/latest", &Credentials{IdentityToken: "refresh"}, http.StatusOK, "Bearer refreshed"},
+		{"basic auth", "/basic/app", &Credentials{Username: "bot", Password: "secret"}, http.StatusOK, "Basic bot"},
+		{"basic auth without credentials", "/basic/app", nil, http.StatusUnauthorized, ""},
+		{"basic auth with a wrong password", "/basic/app", &Credentials{Username: "bot", Password: "wrong"}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configured := map[string]Credentials{}
+			if tt.creds != nil {
+				configured[serverURL.Host] = *tt.creds
+			}
+			req, err := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			resp, err := NewClient(NewAuth(configured)).Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode == http.StatusOK && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("rejected login", func(t *testing.T) {
+		auth := NewAuth(map[string]Credentials{serverURL.Host: {Username: "bot", Password: "wrong"}})
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/v2/app/manifests/latest", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if resp, err := NewClient(auth).Do(req); err == nil {
+			resp.Body.Close()
+			t.Error("Do with a rejected login: error = nil")
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dc-update/internal/retry"
+)
+
+// hubManifestURL is a manifest whose HEAD requests return the Docker Hub pull rate limit
+// without counting as a pull
+const hubManifestURL = "https://registry-1.docker.io/v2/ratelimitpreview/test/manifests/latest"
+
+// checkPolicy retries rate limit checks briefly, they shouldn't hold up the run
+var checkPolicy = retry.Policy{Attempts: 3, InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+
+// RateLimit is the Docker Hub pull budget of an account or IP address
+type RateLimit struct {
+	Limit     int           // pulls allowed per window
+	Remaining int           // pulls left in the current window
+	Window    time.Duration // length of the window, e.g. 6h
+	Source    string        // IP address or account the limit applies to
+	CheckedAt time.Time
+}
+
+// String describes the budget, e.g. "76 of 100 pulls left per 6h"
+func (l *RateLimit) String() string {
+	s := fmt.Sprintf("%d of %d pulls left", l.Remaining, l.Limit)
+	if l.Window > 0 {
+		s += " per " + formatWindow(l.Window)
+	}
+	return s
+}
+
+// formatWindow formats whole hours without minutes and seconds, e.g. "6h"
+func formatWindow(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return fmt.Sprintf("%dh", window/time.Hour)
+	}
+	return window.String()
+}
+
+// CheckHubRateLimit returns the Docker Hub pull budget of the credentials the client has
+// for Docker Hub, or of the IP address of this host without any. It returns nil if Docker
+// Hub reports no limit, as for paid accounts.
+func (c *Client) CheckHubRateLimit(ctx context.Context) (*RateLimit, error) {
+	var limit *RateLimit
+	_, err := retry.Do(ctx, checkPolicy, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, hubManifestURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return retry.NewStatusError(resp)
+		}
+
+		limit, err = parseRateLimit(resp.Header)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the Docker Hub rate limit: %w", err)
+	}
+	return limit, nil
+}
+
+// parseRateLimit reads the ratelimit-limit and ratelimit-remaining headers, e.g.
+// "100;w=21600". It returns nil if they are missing.
+func parseRateLimit(header http.Header) (*RateLimit, error) {
+	limitHeader, remainingHeader := header.Get("ratelimit-limit"), header.Get("ratelimit-remaining")
+	if limitHeader == "" || remainingHeader == "" {
+		return nil, nil
+	}
+
+	limit, window, err := parseQuota(limitHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratelimit-limit header '%s': %w", limitHeader, err)
+	}
+	remaining, _, err := parseQuota(remainingHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratelimit-remaining header '%s': %w", remainingHeader, err)
+	}
+	return &RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Window:    window,
+		Source:    header.Get("docker-ratelimit-source"),
+		CheckedAt: time.Now(),
+	}, nil
+}
+
+// parseQuota parses a quota like "100;w=21600" into the count and the window
+func parseQuota(value string) (int, time.Duration, error) {
+	count, params, _ := strings.Cut(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var window time.Duration
+	for _, param := range strings.Split(params, ";") {
+		if seconds, ok := strings.CutPrefix(strings.TrimSpace(param), "w="); ok {
+			if s, err := strconv.Atoi(seconds); err == nil {
+				window = time.Duration(s) * time.Second
+			}
+		}
+	}
+	return n, window, nil
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     string
+		remaining string
+		source    string
+		want      *RateLimit // CheckedAt is not compared
+		wantErr   bool
+	}{
+		{name: "no headers"},
+		{name: "no remaining header", limit: "100;w=21600"},
+		{
+			name:      "anonymous",
+			limit:     "100;w=21600",
+			remaining: "76;w=21600",
+			source:    "203.0.113.7",
+			want:      &RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour, Source: "203.0.113.7"},
+		},
+		{
+			name:      "spaces and other parameters",
+			limit:     " 200 ; w=21600;comment=\"pulls\"",
+			remaining: "0",
+			want:      &RateLimit{Limit: 200, Remaining: 0, Window: 6 * time.Hour},
+		},
+		{
+			name:      "no window",
+			limit:     "100",
+			remaining: "99",
+			want:      &RateLimit{Limit: 100, Remaining: 99},
+		},
+		{
+			name:      "invalid window",
+			limit:     "100;w=soon",
+			remaining: "99",
+			want:      &RateLimit{Limit: 100, Remaining: 99},
+		},
+		{name: "invalid limit", limit: "many;w=21600", remaining: "76;w=21600", wantErr: true},
+		{name: "invalid remaining", limit: "100;w=21600", remaining: ";w=21600", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, value := range map[string]string{
+				"RateLimit-Limit":         tt.limit,
+				"RateLimit-Remaining":     tt.remaining,
+				"Docker-RateLimit-Source": tt.source,
+			} {
+				if value != "" {
+					header.Set(name, value)
+				}
+			}
+			got, err := parseRateLimit(header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRateLimit error = %v, want error %v", err, tt.wantErr)
+			}
+			if got == nil || tt.want == nil {
+				if got != tt.want {
+					t.Errorf("parseRateLimit = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if got.CheckedAt.IsZero() {
+				t.Error("CheckedAt is not set")
+			}
+			got.CheckedAt = time.Time{}
+			if *got != *tt.want {
+				t.Errorf("parseRateLimit = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitString(t *testing.T) {
+	tests := []struct {
+		limit RateLimit
+		want  string
+	}{
+		{RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour}, "76 of 100 pulls left per 6h"},
+		{RateLimit{Limit: 100, Remaining: 76, Window: 90 * time.Minute}, "76 of 100 pulls left per 1h30m0s"},
+		{RateLimit{Limit: 100, Remaining: 0}, "0 of 100 pulls left"},
+	}
+	for _, tt := range tests {
+		if got := tt.limit.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// DockerHub is the domain of images without a registry in their reference
+const DockerHub = "docker.io"
+
+// Domain returns the registry an image is pulled from, e.g. "ghcr.io" for
+// "ghcr.io/org/app:1.2" and "docker.io" for "nginx". References that can't be parsed
+// return "".
+func Domain(imageRef string) string {
+	named, err := reference.ParseNormalizedNamed(imageRef)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
+
+// Credentials authenticate against a registry. The zero value pulls anonymously.
+type Credentials struct {
+	Username      string
+	Password      string
+	IdentityToken string // refresh token exchanged for access tokens, instead of a password
+}
+
+// Anonymous reports whether no credentials are set
+func (c Credentials) Anonymous() bool {
+	return c.Username == "" && c.Password == "" && c.IdentityToken == ""
+}
+
+// ServerAddress returns the address credentials of a registry domain are stored under,
+// "https://index.docker.io/v1/" for Docker Hub for historical reasons
+func ServerAddress(domain string) string {
+	if domain == DockerHub {
+		return "https://index.docker.io/v1/"
+	}
+	return domain
+}
+
+// normalizeDomain returns the registry domain of a key of the docker CLI configuration,
+// which may be a URL like "https://index.docker.io/v1/"
+func normalizeDomain(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	switch key {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DockerHub
+	}
+	return key
+}
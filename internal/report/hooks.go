@@ -0,0 +1,50 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxHookOutput is the amount of hook output kept in the report, from the end
+const maxHookOutput = 4096
+
+// HookResult holds the outcome of a hook run during a service's update
+type HookResult struct {
+	Event    string // e.g. "pre-restart"
+	Hook     string // description of the hook, e.g. "exec: php occ upgrade"
+	ExitCode int
+	Output   string
+	Duration time.Duration
+	Err      error // set if the hook failed, including a non-zero exit code
+}
+
+// TruncateOutput keeps the end of long hook output, where errors usually are
+func TruncateOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) <= maxHookOutput {
+		return output
+	}
+	return "…" + output[len(output)-maxHookOutput:]
+}
+
+// String describes the hook and its outcome, e.g. "pre-restart: pg_dump app (exit 0, 2s)"
+func (h HookResult) String() string {
+	outcome := fmt.Sprintf("exit %d", h.ExitCode)
+	if h.Err != nil && h.ExitCode == 0 {
+		outcome = h.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s (%s, %s)", h.Event, h.Hook, outcome, h.Duration.Round(time.Millisecond))
+}
+
+// TailLines returns the last n lines of the hook's output
+func (h HookResult) TailLines(n int) []string {
+	if h.Output == "" {
+		return nil
+	}
+	lines := strings.Split(h.Output, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
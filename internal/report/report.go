@@ -0,0 +1,367 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dc-update/internal/docker"
+	"dc-update/internal/registry"
+)
+
+// Status describes the outcome of updating a single service
+type Status string
+
+const (
+	StatusUpdated    Status = "updated"
+	StatusUpToDate   Status = "up-to-date"
+	StatusNotRunning Status = "not-running"
+	StatusFailed     Status = "failed"
+	StatusAvailable  Status = "update-available"
+	StatusDeferred   Status = "deferred"
+	StatusPending    Status = "pending"
+	// StatusInterrupted marks services left alone because the run was interrupted
+	StatusInterrupted Status = "interrupted"
+)
+
+// ServiceResult holds the outcome of updating a single service
+type ServiceResult struct {
+	Service    string
+	Status     Status
+	Image      string
+	OldImageID string
+	NewImageID string
+	OldImage   *docker.ImageInfo
+	NewImage   *docker.ImageInfo
+	// Replicas holds the status of each of the service's containers
+	Replicas []docker.ContainerStatus
+	// PrunedImages lists superseded images removed after the update
+	PrunedImages []string
+	// Hooks lists the hooks run for the service, in order
+	Hooks []HookResult
+	// Backup is the ID of the volume backup taken before the update
+	Backup string
+	// PullAttempts is how often the image was pulled, more than once after retries
+	PullAttempts int
+	// Reason explains why an available update was deferred or is pending, or in which
+	// state an interrupted service was left
+	Reason string
+	Err    error
+}
+
+// InstalledImageID returns the ID of the image the service runs after the update
+func (r ServiceResult) InstalledImageID() string {
+	if r.Status == StatusUpdated {
+		return r.NewImageID
+	}
+	return r.OldImageID
+}
+
+// InstalledImage returns the metadata of the image the service runs after the update
+func (r ServiceResult) InstalledImage() *docker.ImageInfo {
+	if r.Status == StatusUpdated {
+		return r.NewImage
+	}
+	return r.OldImage
+}
+
+// VersionChange describes the change between the old and new image,
+// e.g. "1.25.3 → 1.25.4 (rev abc1234→def5678)"
+func (r ServiceResult) VersionChange() string {
+	if r.OldImage == nil || r.NewImage == nil {
+		if r.OldImageID == "" || r.NewImageID == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s → %s", ShortID(r.OldImageID), ShortID(r.NewImageID))
+	}
+
+	change := fmt.Sprintf("%s → %s", r.OldImage.DisplayVersion(), r.NewImage.DisplayVersion())
+	oldRev, newRev := r.OldImage.ShortRevision(), r.NewImage.ShortRevision()
+	if oldRev != "" && newRev != "" && oldRev != newRev {
+		change += fmt.Sprintf(" (rev %s→%s)", oldRev, newRev)
+	}
+	return change
+}
+
+// Description names the service along with its version change, if known,
+// e.g. "nginx 1.25.3 → 1.25.4 (rev abc1234→def5678)"
+func (r ServiceResult) Description() string {
+	if change := r.VersionChange(); change != "" {
+		return r.Service + " " + change
+	}
+	return r.Service
+}
+
+// ReplicaSummary counts running replicas, e.g. "2/3 replicas running"
+func (r ServiceResult) ReplicaSummary() string {
+	running := 0
+	for _, replica := range r.Replicas {
+		if replica.IsRunning() {
+			running++
+		}
+	}
+	return fmt.Sprintf("%d/%d replicas running", running, len(r.Replicas))
+}
+
+// ShortID truncates an image ID to the 12 characters docker shows by default
+func ShortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// Report collects the results of a dc-update run
+type Report struct {
+	mu         sync.Mutex
+	Hostname   string
+	Project    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Results    []ServiceResult
+	Err        error // error that stopped the whole project, e.g. an invalid compose file
+	// HubRateLimit is the Docker Hub pull budget of the run, counted down by the pulls of
+	// the project, nil if it wasn't checked or Docker Hub reports no limit
+	HubRateLimit *registry.RateLimit
+}
+
+// New creates an empty report for a run of a compose project starting now
+func New(project string) *Report {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &Report{
+		Hostname:  hostname,
+		Project:   project,
+		StartedAt: time.Now(),
+	}
+}
+
+// Add records the result for a service. It is safe for concurrent use.
+func (r *Report) Add(result ServiceResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+// Finish marks the run as complete
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+}
+
+// All returns a copy of all results recorded so far
+func (r *Report) All() []ServiceResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ServiceResult(nil), r.Results...)
+}
+
+// filter returns a copy of all results with the given status
+func (r *Report) filter(status Status) []ServiceResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var results []ServiceResult
+	for _, result := range r.Results {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Changes returns the services that were updated during the run
+func (r *Report) Changes() []ServiceResult {
+	return r.filter(StatusUpdated)
+}
+
+// Failures returns the services that failed to update during the run
+func (r *Report) Failures() []ServiceResult {
+	return r.filter(StatusFailed)
+}
+
+// Deferred returns the services whose available update was held back, e.g. outside
+// their maintenance window
+func (r *Report) Deferred() []ServiceResult {
+	return r.filter(StatusDeferred)
+}
+
+// Pending returns the services whose new image is still younger than the minimum age
+func (r *Report) Pending() []ServiceResult {
+	return r.filter(StatusPending)
+}
+
+// Interrupted returns the services left alone because the run was interrupted
+func (r *Report) Interrupted() []ServiceResult {
+	return r.filter(StatusInterrupted)
+}
+
+// HasActivity reports whether the run updated, failed, deferred, held back or was
+// interrupted before any service
+func (r *Report) HasActivity() bool {
+	return len(r.Changes()) > 0 || len(r.Failures()) > 0 || len(r.Deferred()) > 0 || len(r.Pending()) > 0 || len(r.Interrupted()) > 0
+}
+
+// Count returns the number of results with the given status
+func (r *Report) Count(status Status) int {
+	return len(r.filter(status))
+}
+
+// Headline returns a one-line description of the run, e.g. "2 updated, 1 failed"
+func (r *Report) Headline() string {
+	return headline(r.Count)
+}
+
+// headline describes the number of results per status
+func headline(count func(Status) int) string {
+	parts := []string{
+		fmt.Sprintf("%d updated", count(StatusUpdated)),
+		fmt.Sprintf("%d up to date", count(StatusUpToDate)),
+	}
+	if n := count(StatusAvailable); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d available", n))
+	}
+	if n := count(StatusPending); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d pending", n))
+	}
+	if n := count(StatusDeferred); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d deferred", n))
+	}
+	if n := count(StatusNotRunning); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d not running", n))
+	}
+	if n := count(StatusFailed); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", n))
+	}
+	if n := count(StatusInterrupted); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d interrupted", n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Summary returns the headline followed by one line per service that changed or was held back
+func (r *Report) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary: %s\n", r.Headline())
+	writeRateLimit(&b, r.HubRateLimit)
+	r.writeResults(&b, "  ")
+	return b.String()
+}
+
+// CombinedSummary returns the overall headline of several projects, followed by each
+// project's headline and its updated or failed services
+func CombinedSummary(reports []*Report) string {
+	total := func(status Status) int {
+		n := 0
+		for _, r := range reports {
+			n += r.Count(status)
+		}
+		return n
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of %d projects: %s\n", len(reports), headline(total))
+	writeRateLimit(&b, latestRateLimit(reports))
+	for _, r := range reports {
+		if r.Err != nil {
+			fmt.Fprintf(&b, "  ❌ %s: %v\n", r.Project, r.Err)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", r.Project, r.Headline())
+		r.writeResults(&b, "    ")
+	}
+	return b.String()
+}
+
+// latestRateLimit returns the Docker Hub pull budget checked last by any of the reports,
+// as projects share it. Projects counting down the same check left the least of it last.
+func latestRateLimit(reports []*Report) *registry.RateLimit {
+	var latest *registry.RateLimit
+	for _, r := range reports {
+		limit := r.HubRateLimit
+		if limit == nil {
+			continue
+		}
+		if latest == nil || limit.CheckedAt.After(latest.CheckedAt) ||
+			(limit.CheckedAt.Equal(latest.CheckedAt) && limit.Remaining < latest.Remaining) {
+			latest = limit
+		}
+	}
+	return latest
+}
+
+// writeRateLimit writes the Docker Hub pull budget, if it was checked
+func writeRateLimit(b *strings.Builder, limit *registry.RateLimit) {
+	if limit != nil {
+		fmt.Fprintf(b, "  🐳 Docker Hub: %s\n", limit)
+	}
+}
+
+// writeResults writes one line per updated, failed, pending, deferred or interrupted service
+func (r *Report) writeResults(b *strings.Builder, indent string) {
+	for _, result := range r.Changes() {
+		fmt.Fprintf(b, "%s✅ %s", indent, result.Description())
+		if n := len(result.PrunedImages); n > 0 {
+			fmt.Fprintf(b, " (pruned %d old image(s))", n)
+		}
+		if result.Backup != "" {
+			fmt.Fprintf(b, " (volumes backed up as %s)", result.Backup)
+		}
+		if result.PullAttempts > 1 {
+			fmt.Fprintf(b, " (pulled after %d attempts)", result.PullAttempts)
+		}
+		b.WriteString("\n")
+		writeReplicas(b, indent, result)
+		writeHooks(b, indent, result)
+	}
+	for _, result := range r.Failures() {
+		fmt.Fprintf(b, "%s❌ %s: %v\n", indent, result.Service, result.Err)
+		if result.Backup != "" {
+			fmt.Fprintf(b, "%s    volumes backed up as %s, see `dc-update restore %s %s`\n", indent, result.Backup, result.Service, result.Backup)
+		}
+		writeReplicas(b, indent, result)
+		writeHooks(b, indent, result)
+	}
+	for _, result := range r.Pending() {
+		fmt.Fprintf(b, "%s⏳ %s: pending (%s)\n", indent, result.Description(), result.Reason)
+	}
+	for _, result := range r.Deferred() {
+		fmt.Fprintf(b, "%s⏸️  %s: %s\n", indent, result.Description(), result.Reason)
+	}
+	for _, result := range r.Interrupted() {
+		fmt.Fprintf(b, "%s⏹️  %s: %s\n", indent, result.Service, result.Reason)
+	}
+}
+
+// writeHooks lists the hooks run for a service, with the end of the output of failed ones
+func writeHooks(b *strings.Builder, indent string, result ServiceResult) {
+	for _, hook := range result.Hooks {
+		mark := "✔"
+		if hook.Err != nil {
+			mark = "✘"
+		}
+		fmt.Fprintf(b, "%s    %s hook %s\n", indent, mark, hook.String())
+		if hook.Err != nil {
+			for _, line := range hook.TailLines(5) {
+				fmt.Fprintf(b, "%s      │ %s\n", indent, line)
+			}
+		}
+	}
+}
+
+// writeReplicas lists per-replica status for services with more than one container
+func writeReplicas(b *strings.Builder, indent string, result ServiceResult) {
+	if len(result.Replicas) <= 1 {
+		return
+	}
+	for _, replica := range result.Replicas {
+		fmt.Fprintf(b, "%s    %s\n", indent, replica.String())
+	}
+}
@@ -0,0 +1,202 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for retrying registry operations
+const (
+	DefaultAttempts     = 4
+	DefaultInitialDelay = 2 * time.Second
+	DefaultMaxDelay     = time.Minute
+	// MaxRetryAfter is the longest Retry-After a registry may ask for before the operation
+	// is given up instead of waited for
+	MaxRetryAfter = 5 * time.Minute
+)
+
+// Policy describes how often and how patiently an operation is retried
+type Policy struct {
+	Attempts     int           // total number of attempts, 1 disables retries
+	InitialDelay time.Duration // delay before the second attempt, doubled for each further one
+	MaxDelay     time.Duration // upper bound of the delay between attempts
+}
+
+// DefaultPolicy returns the policy used for pulls and registry calls
+func DefaultPolicy() Policy {
+	return Policy{
+		Attempts:     DefaultAttempts,
+		InitialDelay: DefaultInitialDelay,
+		MaxDelay:     DefaultMaxDelay,
+	}
+}
+
+// StatusError is an HTTP error response of a registry
+type StatusError struct {
+	URL        string
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, 0 if not given
+}
+
+// Error describes the response, e.g. "GET https://...: 429 Too Many Requests"
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// NewStatusError returns the error of an unsuccessful registry response
+func NewStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        resp.Request.Method + " " + resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header, given in seconds or as an HTTP date
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ErrTimeLimit matches the errors of operations that ran into the time limit dc-update
+// sets for them, e.g. --pull-timeout. They aren't retried, as another attempt could take
+// as long again.
+var ErrTimeLimit = errors.New("time limit exceeded")
+
+// Permanent and transient failures recognized in the messages of docker, compose and
+// registries, in lower case. Permanent ones are checked first, as messages like "pull
+// access denied ... may require 'docker login'" also mention retryable-looking words.
+var (
+	permanentMessages = []string{
+		"manifest unknown",
+		"not found",
+		"unauthorized",
+		"access denied",
+		"denied:",
+		"requested access to the resource is denied",
+		"authentication required",
+		"invalid reference format",
+		"no matching manifest",
+	}
+	transientMessages = []string{
+		"toomanyrequests",
+		"too many requests",
+		"timeout",
+		"timed out",
+		"deadline exceeded",
+		"connection reset",
+		"connection refused",
+		"broken pipe",
+		"unexpected eof",
+		"tls handshake",
+		"no such host",
+		"temporary failure",
+		"service unavailable",
+		"bad gateway",
+		"gateway timeout",
+		"internal server error",
+		" 500 ",
+		" 502 ",
+		" 503 ",
+		" 504 ",
+	}
+)
+
+// Retryable reports whether an error is worth retrying: network errors and timeouts,
+// server errors and rate limits are, missing manifests and denied access aren't. Errors
+// that are recognized as neither aren't retried. Neither is a cancelled operation nor one
+// that ran into its time limit (ErrTimeLimit).
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeLimit) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return statusErr.RetryAfter <= MaxRetryAfter
+		case statusErr.StatusCode == http.StatusRequestTimeout, statusErr.StatusCode >= 500:
+			return true
+		default:
+			return false
+		}
+	}
+	message := " " + strings.ToLower(err.Error()) + " "
+	for _, permanent := range permanentMessages {
+		if strings.Contains(message, permanent) {
+			return false
+		}
+	}
+	for _, transient := range transientMessages {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns how long to wait before the attempt after the given one: the delay a
+// registry asked for with Retry-After, or an exponential backoff with jitter, so hosts
+// failing at the same time don't retry in lockstep
+func (p Policy) Delay(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	delay := p.InitialDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	// Wait at least half of the backoff, and a random part of the rest
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Do runs fn until it succeeds, fails with an error that isn't retryable or runs out of
+// attempts. It returns the number of attempts made and the last error.
+func Do(ctx context.Context, p Policy, fn func() error) (int, error) {
+	return Continue(ctx, p, 1, fn(), fn)
+}
+
+// Continue retries an operation that already failed attempts times, the last time with
+// err, like Do. Once ctx is done, it isn't retried anymore.
+func Continue(ctx context.Context, p Policy, attempts int, err error, fn func() error) (int, error) {
+	for err != nil && attempts < p.Attempts && ctx.Err() == nil && Retryable(err) {
+		timer := time.NewTimer(p.Delay(attempts, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		case <-timer.C:
+		}
+		attempts++
+		err = fn()
+	}
+	return attempts, err
+}
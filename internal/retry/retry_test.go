@@ -0,0 +1,192 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// timeLimitError stands in for the errors of operations that ran into dc-update's own time
+// limit, like docker.TimeoutError
+type timeLimitError struct{}
+
+func (timeLimitError) Error() string        { return "timed out after 5m0s" }
+func (timeLimitError) Timeout() bool        { return true }
+func (timeLimitError) Is(target error) bool { return target == ErrTimeLimit }
+
+// timeoutErr is a network error that timed out, like those of net.Conn deadlines
+type timeoutErr struct{}
+
+func (*timeoutErr) Error() string   { return "i/o timeout" }
+func (*timeoutErr) Timeout() bool   { return true }
+func (*timeoutErr) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	netTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: &timeoutErr{}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"500", &StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{"503", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"408", &StatusError{StatusCode: http.StatusRequestTimeout}, true},
+		{"404", &StatusError{StatusCode: http.StatusNotFound}, false},
+		{"401", &StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{"429 without Retry-After", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"429 with short Retry-After", &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}, true},
+		{"429 with too long Retry-After", &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}, false},
+		{"wrapped 502", fmt.Errorf("check: %w", &StatusError{StatusCode: http.StatusBadGateway}), true},
+		{"net timeout", netTimeout, true},
+		{"http client timeout", &url.Error{Op: "Get", URL: "https://registry-1.docker.io/v2/", Err: netTimeout}, true},
+		{"deadline exceeded", fmt.Errorf("request: %w", context.DeadlineExceeded), true},
+		{"cancelled", fmt.Errorf("request: %w", context.Canceled), false},
+		{"own time limit", fmt.Errorf("failed to pull: %w", timeLimitError{}), false},
+		{"connection reset", errors.New("read tcp 10.0.0.1:443: connection reset by peer"), true},
+		{"tls handshake timeout", errors.New("net/http: TLS handshake timeout"), true},
+		{"rate limited pull", errors.New("toomanyrequests: You have reached your pull rate limit"), true},
+		{"compose 503", errors.New("error response from daemon: received unexpected HTTP status: 503 Service Unavailable"), true},
+		{"manifest unknown", errors.New("manifest unknown: manifest unknown"), false},
+		{"access denied mentioning a timeout", errors.New("pull access denied for app, repository does not exist or may require 'docker login': timeout"), false},
+		{"unrecognized", errors.New("exit status 1"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Retryable(tt.err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{Attempts: 5, InitialDelay: 2 * time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{"first retry", 1, errors.New("connection reset"), time.Second, 2 * time.Second},
+		{"second retry", 2, errors.New("connection reset"), 2 * time.Second, 4 * time.Second},
+		{"third retry", 3, errors.New("connection reset"), 4 * time.Second, 8 * time.Second},
+		{"capped", 6, errors.New("connection reset"), 5 * time.Second, 10 * time.Second},
+		{"Retry-After", 1, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second},
+		{"Retry-After beyond the backoff", 6, &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}, time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The jitter is random, so check the bounds a few times
+			for i := 0; i < 50; i++ {
+				if got := p.Delay(tt.attempt, tt.err); got < tt.min || got > tt.max {
+					t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+
+	if got := (Policy{Attempts: 3}).Delay(1, errors.New("connection reset")); got != 0 {
+		t.Errorf("Delay without an initial delay = %s, want 0", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"Sun, 18 Oct 2026 12:01:30 GMT", 90 * time.Second},
+		{"Sun, 18 Oct 2026 11:59:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestContinue(t *testing.T) {
+	transient := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	permanent := &StatusError{StatusCode: http.StatusNotFound}
+	p := Policy{Attempts: 4}
+
+	tests := []struct {
+		name         string
+		results      []error // returned by the retries, in order
+		wantAttempts int
+		wantErr      error
+	}{
+		{"succeeds on retry", []error{transient, nil}, 3, nil},
+		{"gives up after the attempts", []error{transient, transient, transient}, 4, transient},
+		{"stops at a permanent error", []error{permanent}, 2, permanent},
+		{"stops at the own time limit", []error{timeLimitError{}}, 2, timeLimitError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			attempts, err := Continue(context.Background(), p, 1, transient, func() error {
+				calls++
+				if calls > len(tt.results) {
+					t.Fatalf("retried %d times, more than expected", calls)
+				}
+				return tt.results[calls-1]
+			})
+			if attempts != tt.wantAttempts || err != tt.wantErr {
+				t.Errorf("Continue = %d, %v, want %d, %v", attempts, err, tt.wantAttempts, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("stops once the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls := 0
+		attempts, err := Continue(ctx, p, 1, transient, func() error {
+			calls++
+			return nil
+		})
+		if calls != 0 || attempts != 1 || err != transient {
+			t.Errorf("Continue with a cancelled context = %d, %v after %d calls, want 1, %v after none", attempts, err, calls, transient)
+		}
+	})
+
+	t.Run("stops waiting when the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		slow := Policy{Attempts: 4, InitialDelay: time.Hour, MaxDelay: time.Hour}
+		start := time.Now()
+		attempts, err := Continue(ctx, slow, 1, transient, func() error {
+			t.Fatal("retried after the context was cancelled")
+			return nil
+		})
+		if attempts != 1 || err != transient || time.Since(start) > 5*time.Second {
+			t.Errorf("Continue = %d, %v after %s, want 1, %v right away", attempts, err, time.Since(start), transient)
+		}
+	})
+}
+
+func TestDo(t *testing.T) {
+	calls := 0
+	attempts, err := Do(context.Background(), Policy{Attempts: 3}, func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if attempts != 2 || err != nil {
+		t.Errorf("Do = %d, %v, want 2, nil", attempts, err)
+	}
+}
@@ -0,0 +1,202 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// The container image doesn't ship a timezone database
+	_ "time/tzdata"
+)
+
+// Window is a recurring maintenance window, e.g. "Sun 02:00-05:00 Europe/Berlin". A window
+// whose end is not after its start ends on the following day.
+type Window struct {
+	spec     string
+	days     [7]bool // indexed by time.Weekday
+	start    int     // minutes after midnight
+	end      int     // minutes after midnight, up to 24:00
+	location *time.Location
+}
+
+// dayNames maps abbreviated day names to weekdays
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWindow parses a window of the form "DAYS HH:MM-HH:MM [TIMEZONE]". DAYS is a comma
+// separated list of days and day ranges, e.g. "Sat,Sun" or "Mon-Fri", or "daily". The
+// timezone is an IANA name and defaults to the local timezone.
+func ParseWindow(spec string) (Window, error) {
+	w := Window{spec: spec, location: time.Local}
+
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields) > 3 {
+		return w, fmt.Errorf("invalid maintenance window '%s' (expected e.g. \"Sun 02:00-05:00 Europe/Berlin\")", spec)
+	}
+
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, fmt.Errorf("invalid maintenance window '%s': %w", spec, err)
+	}
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid maintenance window '%s': expected a time range like 02:00-05:00", spec)
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, fmt.Errorf("invalid maintenance window '%s': %w", spec, err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, fmt.Errorf("invalid maintenance window '%s': %w", spec, err)
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("invalid maintenance window '%s': the window is empty", spec)
+	}
+
+	if len(fields) == 3 {
+		if w.location, err = time.LoadLocation(fields[2]); err != nil {
+			return w, fmt.Errorf("invalid maintenance window '%s': unknown timezone %s", spec, fields[2])
+		}
+	}
+	return w, nil
+}
+
+// parseDays parses the days a window opens on
+func (w *Window) parseDays(spec string) error {
+	if strings.EqualFold(spec, "daily") || spec == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := dayNames[strings.ToLower(first)]
+		if !ok {
+			return fmt.Errorf("unknown day '%s'", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = dayNames[strings.ToLower(last)]; !ok {
+				return fmt.Errorf("unknown day '%s'", last)
+			}
+		}
+		// Ranges may wrap around the week, e.g. "Fri-Mon"
+		for day := from; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses a time of day like "02:00" into minutes after midnight
+func parseClock(clock string) (int, error) {
+	hours, minutes, ok := strings.Cut(clock, ":")
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if !ok || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time '%s' (expected HH:MM)", clock)
+	}
+	return h*60 + m, nil
+}
+
+// String returns the window as it was written
+func (w Window) String() string {
+	return w.spec
+}
+
+// occurrence returns the start and end of the window opening on the day of t
+func (w Window) occurrence(t time.Time) (time.Time, time.Time) {
+	year, month, day := t.Date()
+	start := time.Date(year, month, day, 0, w.start, 0, 0, w.location)
+	endDay := day
+	if w.end <= w.start {
+		endDay++
+	}
+	end := time.Date(year, month, endDay, 0, w.end, 0, 0, w.location)
+	return start, end
+}
+
+// Contains reports whether the window is open at t
+func (w Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	// A window opened yesterday may still be open
+	for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		start, end := w.occurrence(day)
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the window opens next after t
+func (w Window) Next(t time.Time) time.Time {
+	t = t.In(w.location)
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		if start, _ := w.occurrence(day); start.After(t) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+// Windows is a set of maintenance windows. Updates are allowed while any of them is open,
+// and at any time if there are none.
+type Windows []Window
+
+// ParseWindows parses a list of windows
+func ParseWindows(specs []string) (Windows, error) {
+	windows := make(Windows, 0, len(specs))
+	for _, spec := range specs {
+		window, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// Open reports whether updates are allowed at t
+func (ws Windows) Open(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the first of the windows opens next after t
+func (ws Windows) Next(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		if start := w.Next(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
@@ -0,0 +1,180 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a time in the given timezone
+func at(t *testing.T, zone string, value string) time.Time {
+	t.Helper()
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatalf("load %s: %v", zone, err)
+	}
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+	if err != nil {
+		t.Fatalf("parse %s: %v", value, err)
+	}
+	return parsed
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"Sun 02:00-05:00", false},
+		{"Sun 02:00-05:00 Europe/Berlin", false},
+		{"daily 02:00-03:00 UTC", false},
+		{"* 02:00-03:00", false},
+		{"Mon-Fri 09:00-17:00", false},
+		{"Fri-Mon 22:00-24:00", false},
+		{"sat,SUN 00:00-24:00", false},
+		{"Fri 22:00-02:00", false},
+		{"", true},
+		{"Sun", true},
+		{"Sun 02:00-05:00 UTC extra", true},
+		{"Someday 02:00-05:00", true},
+		{"Mon-Someday 02:00-05:00", true},
+		{"Sun 02:00", true},
+		{"Sun 2-5", true},
+		{"Sun 25:00-03:00", true},
+		{"Sun 24:30-03:00", true},
+		{"Sun 02:60-03:00", true},
+		{"Sun -1:00-03:00", true},
+		{"Sun 02:00-02:00", true},
+		{"Sun 02:00-05:00 Mars/Olympus_Mons", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWindow(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if err == nil && w.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", w.String(), tt.spec)
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		zone string // of the time checked
+		time string
+		want bool
+	}{
+		{"inside", "Sun 02:00-05:00 UTC", "UTC", "2026-10-18 03:00", true},
+		{"at the start", "Sun 02:00-05:00 UTC", "UTC", "2026-10-18 02:00", true},
+		{"at the end", "Sun 02:00-05:00 UTC", "UTC", "2026-10-18 05:00", false},
+		{"before", "Sun 02:00-05:00 UTC", "UTC", "2026-10-18 01:59", false},
+		{"other day", "Sun 02:00-05:00 UTC", "UTC", "2026-10-19 03:00", false},
+		{"weekday range", "Mon-Fri 09:00-17:00 UTC", "UTC", "2026-10-21 12:00", true},
+		{"weekday range on a weekend", "Mon-Fri 09:00-17:00 UTC", "UTC", "2026-10-24 12:00", false},
+		{"range wrapping the week", "Fri-Mon 10:00-11:00 UTC", "UTC", "2026-10-18 10:30", true},
+		{"range wrapping the week, outside", "Fri-Mon 10:00-11:00 UTC", "UTC", "2026-10-21 10:30", false},
+		{"daily", "daily 02:00-03:00 UTC", "UTC", "2026-10-21 02:30", true},
+		{"until midnight", "Sat 22:00-24:00 UTC", "UTC", "2026-10-17 23:59", true},
+		{"until midnight, next day", "Sat 22:00-24:00 UTC", "UTC", "2026-10-18 00:00", false},
+
+		// Overnight windows belong to the day they open on
+		{"overnight, evening", "Fri 22:00-02:00 UTC", "UTC", "2026-10-16 23:00", true},
+		{"overnight, after midnight", "Fri 22:00-02:00 UTC", "UTC", "2026-10-17 01:00", true},
+		{"overnight, at the end", "Fri 22:00-02:00 UTC", "UTC", "2026-10-17 02:00", false},
+		{"overnight, evening of the next day", "Fri 22:00-02:00 UTC", "UTC", "2026-10-17 23:00", false},
+		{"overnight, early on the opening day", "Fri 22:00-02:00 UTC", "UTC", "2026-10-16 01:00", false},
+		{"overnight across the week", "Sat 23:00-01:00 UTC", "UTC", "2026-10-18 00:30", true},
+
+		// Times in other timezones are converted to the window's
+		{"other timezone, inside", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-10-18 01:30", true},
+		{"other timezone, outside", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-10-18 03:30", false},
+		{"timezone shifts the day", "Mon 01:00-02:00 Asia/Tokyo", "UTC", "2026-10-18 16:30", true},
+		{"timezone shifts the day, outside", "Mon 01:00-02:00 Asia/Tokyo", "UTC", "2026-10-19 16:30", false},
+
+		// Berlin switches to summer time on 2026-03-29, 02:00 becomes 03:00
+		{"spring forward, skipped hour", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-03-29 01:30", true},
+		{"spring forward, before", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-03-29 00:30", false},
+		{"spring forward, last minute", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-03-29 02:59", true},
+		{"spring forward, end", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-03-29 03:00", false},
+		// and back on 2026-10-25, 03:00 becomes 02:00 again
+		{"fall back, after the repeated hour", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-10-25 02:30", true},
+		{"fall back, last minute", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-10-25 03:59", true},
+		{"fall back, end", "Sun 02:00-05:00 Europe/Berlin", "UTC", "2026-10-25 04:00", false},
+		{"overnight across fall back", "Sat 23:00-04:00 Europe/Berlin", "UTC", "2026-10-25 02:30", true},
+		{"overnight across fall back, end", "Sat 23:00-04:00 Europe/Berlin", "UTC", "2026-10-25 03:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseWindow(%q): %v", tt.spec, err)
+			}
+			if got := w.Contains(at(t, tt.zone, tt.time)); got != tt.want {
+				t.Errorf("%q.Contains(%s %s) = %v, want %v", tt.spec, tt.time, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowNext(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		time string // UTC
+		want string // UTC
+	}{
+		{"later today", "Sun 02:00-05:00 UTC", "2026-10-18 01:00", "2026-10-18 02:00"},
+		{"while open, the next week", "Sun 02:00-05:00 UTC", "2026-10-18 03:00", "2026-10-25 02:00"},
+		{"at the start, the next week", "Sun 02:00-05:00 UTC", "2026-10-18 02:00", "2026-10-25 02:00"},
+		{"later this week", "Wed 02:00-05:00 UTC", "2026-10-18 12:00", "2026-10-21 02:00"},
+		{"daily", "daily 02:00-03:00 UTC", "2026-10-18 12:00", "2026-10-19 02:00"},
+		{"overnight", "Fri 22:00-02:00 UTC", "2026-10-17 01:00", "2026-10-23 22:00"},
+		{"other timezone", "Sun 02:00-05:00 Europe/Berlin", "2026-10-17 12:00", "2026-10-18 00:00"},
+		{"into summer time", "Sun 04:00-05:00 Europe/Berlin", "2026-03-28 12:00", "2026-03-29 02:00"},
+		{"into winter time", "Sun 04:00-05:00 Europe/Berlin", "2026-10-24 12:00", "2026-10-25 03:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseWindow(%q): %v", tt.spec, err)
+			}
+			want := at(t, "UTC", tt.want)
+			if got := w.Next(at(t, "UTC", tt.time)); !got.Equal(want) {
+				t.Errorf("%q.Next(%s) = %s, want %s", tt.spec, tt.time, got.UTC(), want)
+			}
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	var none Windows
+	now := at(t, "UTC", "2026-10-18 12:00")
+	if !none.Open(now) {
+		t.Error("no windows: Open = false, want true")
+	}
+	if next := none.Next(now); !next.IsZero() {
+		t.Errorf("no windows: Next = %s, want zero", next)
+	}
+
+	windows, err := ParseWindows([]string{"Sat 02:00-04:00 UTC", "Tue 22:00-23:00 UTC"})
+	if err != nil {
+		t.Fatalf("ParseWindows: %v", err)
+	}
+	if windows.Open(now) {
+		t.Error("Open on Sunday noon = true, want false")
+	}
+	if !windows.Open(at(t, "UTC", "2026-10-20 22:30")) {
+		t.Error("Open on Tuesday 22:30 = false, want true")
+	}
+	if want := at(t, "UTC", "2026-10-20 22:00"); !windows.Next(now).Equal(want) {
+		t.Errorf("Next = %s, want the earlier window at %s", windows.Next(now), want)
+	}
+
+	if _, err := ParseWindows([]string{"Sat 02:00-04:00", "Someday 01:00-02:00"}); err == nil {
+		t.Error("ParseWindows with an invalid window: error = nil")
+	}
+}
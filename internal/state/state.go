@@ -0,0 +1,147 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Freeze holds back updates of a service, either entirely or only to one image
+type Freeze struct {
+	Project   string     `json:"project"`
+	Service   string     `json:"service"`
+	Until     *time.Time `json:"until,omitempty"`  // the freeze lasts until removed if nil
+	Digest    string     `json:"digest,omitempty"` // only this image is held back if set
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Active reports whether the freeze is still in effect at now
+func (f Freeze) Active(now time.Time) bool {
+	return f.Until == nil || now.Before(*f.Until)
+}
+
+// Matches reports whether the freeze holds back an image, given its ID and repository
+// digests (e.g. "nginx@sha256:...")
+func (f Freeze) Matches(imageID string, repoDigests []string) bool {
+	if f.Digest == "" {
+		return true
+	}
+	// Digests may be shortened like image IDs, e.g. to the 12 characters docker shows
+	digest := strings.TrimPrefix(f.Digest, "sha256:")
+	if strings.HasPrefix(strings.TrimPrefix(imageID, "sha256:"), digest) {
+		return true
+	}
+	for _, repoDigest := range repoDigests {
+		if _, d, ok := strings.Cut(repoDigest, "@"); ok && strings.HasPrefix(strings.TrimPrefix(d, "sha256:"), digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the freeze, e.g. "frozen until 2026-11-01 00:00 CET (bad release)"
+func (f Freeze) String() string {
+	desc := "frozen"
+	if f.Digest != "" {
+		desc = fmt.Sprintf("release %s skipped", f.Digest)
+	}
+	if f.Until != nil {
+		desc += " until " + f.Until.Local().Format("2006-01-02 15:04 MST")
+	}
+	if f.Reason != "" {
+		desc += fmt.Sprintf(" (%s)", f.Reason)
+	}
+	return desc
+}
+
+// State is what dc-update remembers between runs
+type State struct {
+	Freezes []Freeze `json:"freezes"`
+}
+
+// Load reads the state file. A missing file is an empty state.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %w", path, err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// Save writes the state file, replacing it atomically
+func (s *State) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write state %s: %w", path, err)
+	}
+	return nil
+}
+
+// AddFreeze adds a freeze, replacing one of the same service and digest
+func (s *State) AddFreeze(freeze Freeze) {
+	for i, existing := range s.Freezes {
+		if existing.Project == freeze.Project && existing.Service == freeze.Service && existing.Digest == freeze.Digest {
+			s.Freezes[i] = freeze
+			return
+		}
+	}
+	s.Freezes = append(s.Freezes, freeze)
+}
+
+// RemoveFreezes removes the freezes of a service and returns how many there were
+func (s *State) RemoveFreezes(project string, service string) int {
+	kept := s.Freezes[:0]
+	for _, freeze := range s.Freezes {
+		if freeze.Project != project || freeze.Service != service {
+			kept = append(kept, freeze)
+		}
+	}
+	removed := len(s.Freezes) - len(kept)
+	s.Freezes = kept
+	return removed
+}
+
+// PruneFreezes drops the freezes that ended before now
+func (s *State) PruneFreezes(now time.Time) {
+	kept := s.Freezes[:0]
+	for _, freeze := range s.Freezes {
+		if freeze.Active(now) {
+			kept = append(kept, freeze)
+		}
+	}
+	s.Freezes = kept
+}
+
+// ActiveFreezes returns the freezes of a service in effect at now
+func (s *State) ActiveFreezes(project string, service string, now time.Time) []Freeze {
+	var freezes []Freeze
+	for _, freeze := range s.Freezes {
+		if freeze.Project == project && freeze.Service == service && freeze.Active(now) {
+			freezes = append(freezes, freeze)
+		}
+	}
+	return freezes
+}